@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	aws_lambda "github.com/aws/aws-lambda-go/lambda"
 	"github.com/go-iiif/go-iiif-aws/ecs"
 	"github.com/go-iiif/go-iiif-uri"
 	"github.com/whosonfirst/go-whosonfirst-cli/flags"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -19,15 +26,83 @@ func main() {
 	var cluster = flag.String("cluster", "", "The name of your AWS ECS cluster.")
 	var task = flag.String("task", "", "The name of your AWS ECS task (inclusive of its version number),")
 
-	var config = flag.String("config", "/etc/go-iiif/config.json", "The path your IIIF config (on/in your container).")
-	var instructions = flag.String("instructions", "/etc/go-iiif/instructions.json", "The path your IIIF processing instructions (on/in your container).")
+	var launch_type = flag.String("launch-type", "", "The launch type for your AWS ECS task. Valid options are: FARGATE, FARGATE_SPOT, EC2. If empty (and no -capacity-provider flags are set) then FARGATE is assumed.")
+	var platform_version = flag.String("platform-version", "", "The Fargate platform version for your AWS ECS task. If empty then LATEST is assumed.")
+
+	var capacity_providers flags.MultiString
+	flag.Var(&capacity_providers, "capacity-provider", "One or more capacity providers in the form of NAME[:WEIGHT[:BASE]] (for example FARGATE_SPOT:3 or FARGATE:1:1). Multiple providers may also be passed as comma-separated values. Can not be used with -launch-type.")
+
+	var cpu = flag.Int64("cpu", 0, "The number of CPU units (1024 is one vCPU) to assign to your task. If 0 then the value in your task definition is used.")
+	var memory = flag.Int64("memory", 0, "The amount of memory (in MiB) to assign to your task. If 0 then the value in your task definition is used.")
+	var sizing_policy = flag.String("sizing-policy", "", "The path to (or the body of) a JSON-encoded sizing policy used to assign CPU and memory to tasks based on the size of the source images they will process. Ignored if -cpu or -memory are set.")
+
+	var config = flag.String("config", "/etc/go-iiif/config.json", "The path your IIIF config (on/in your container). Ignored if -config-source is set.")
+	var config_source = flag.String("config-source", "", "A valid Go Cloud bucket URI (for example s3://bucket?region=us-east-1) where your IIIF config is located. If set, tasks read their config from it rather than from the container.")
+	var config_name = flag.String("config-name", ecs.DEFAULT_CONFIG_NAME, "The name of your IIIF config in -config-source.")
+
+	var instructions = flag.String("instructions", "/etc/go-iiif/instructions.json", "The path your IIIF processing instructions (on/in your container). Ignored if -instructions-source is set.")
+	var instructions_source = flag.String("instructions-source", "", "A valid Go Cloud bucket URI (for example s3://bucket?region=us-east-1) where your IIIF processing instructions are located. If set, tasks read their instructions from it rather than from the container.")
+	var instructions_name = flag.String("instructions-name", ecs.DEFAULT_INSTRUCTIONS_NAME, "The name of your IIIF processing instructions in -instructions-source.")
+	var instructions_document = flag.String("instructions-document", "", "The path to (or the body of) a JSON-encoded IIIF processing instructions document. If set, it is uploaded to -staging-source when a task is launched and used instead of -instructions and -instructions-source.")
+
+	var staging_source = flag.String("staging-source", "", "A valid Go Cloud S3 bucket URI (for example s3://bucket?region=us-east-1) where instructions documents and manifests are staged.")
+	var staging_prefix = flag.String("staging-prefix", ecs.DEFAULT_STAGING_PREFIX, "The key prefix for instructions documents and manifests in -staging-source.")
+	var staging_dsn = flag.String("staging-dsn", "", "A valid (go-whosonfirst-aws) S3 DSN. If empty the value of -ecs-dsn is used.")
+	var manifest = flag.Bool("manifest", false, "Pass URIs to tasks in a JSONL manifest in -staging-source, rather than on the command line, so they aren't subject to the ECS overrides size limit and don't appear in the ECS console or CloudTrail. Requires that your container has the iiif-process-manifest wrapper.")
+	var cleanup_instructions = flag.Bool("cleanup-instructions", false, "Remove staged instructions documents once the tasks for a job have stopped. This happens after waiting for tasks (-wait) or, when running in lambda-ecs mode, once the last task for a job has stopped.")
+
+	var source_map = flag.String("source-map", "", "The path to (or the body of) a JSON-encoded source map defining the IIIF config and instructions to use for each S3 bucket (and optionally key prefix) when running as a Lambda function. If set, objects in buckets without a mapping are skipped.")
+
+	var derivatives_configs flags.MultiString
+	flag.Var(&derivatives_configs, "derivatives-config", "The path to (or the body of) one or more (local copies of your) IIIF config files. Events for objects in their derivatives caches are skipped when processing S3 events, to prevent derivatives from triggering more tasks.")
+
+	var include flags.MultiString
+	flag.Var(&include, "include", "One or more glob patterns. If set, only S3 objects whose keys match are processed when processing S3 events. Patterns without a \"/\" are matched against the last element of the key.")
+
+	var exclude flags.MultiString
+	flag.Var(&exclude, "exclude", "One or more glob patterns. S3 objects whose keys match are skipped when processing S3 events. Patterns without a \"/\" are matched against the last element of the key.")
 
 	var report = flag.Bool("report", false, "Store a process report (JSON) for each URI in the cache tree.")
 	var report_name = flag.String("report-name", "process.json", "The filename for process reports. Default is 'process.json' as in '${URI}/process.json'.")
 
-	var wait = flag.Bool("wait", false, "Wait for the task to complete.")
+	var wait = flag.Bool("wait", false, "Wait for the task to complete. If any of the task's containers exit with a non-zero status the tool will exit with an error.")
+
+	var follow = flag.Bool("follow", false, "Print the task's CloudWatch log stream to STDERR while it runs. Implies -wait.")
+
+	var max_uris = flag.Int("max-uris-per-task", 0, "The maximum number of URIs to process in a single task. URIs are also split across multiple tasks if the command to process them would exceed the ECS overrides size limit. If 0 there is no limit.")
+	var concurrency = flag.Int("concurrency", 4, "The maximum number of tasks to launch (and wait on) at the same time when URIs are split across multiple tasks.")
+
+	var max_attempts = flag.Int("max-attempts", 3, "The maximum number of times to try launching a task if ECS is throttling requests or reports a (retryable) capacity error.")
+	var max_elapsed = flag.Duration("max-elapsed", 0, "The maximum amount of time to spend retrying a task launch. If 0 there is no limit.")
+	var rotate_subnets = flag.Bool("rotate-subnets", false, "If true then retry a task launch that failed because of a capacity error in the next subnet (and availability zone).")
+
+	var job_id = flag.String("job-id", "", "An optional identifier for this job. If empty a random ID is generated for each job. It is included in task responses and callbacks, recorded as the task's startedBy property (if it is 36 characters or less and only contains letters, numbers, hyphens and underscores) and iiif-process:job-id tag and passed to the container as the IIIF_PROCESS_JOB_ID environment variable.")
+
+	var tags flags.MultiString
+	flag.Var(&tags, "tag", "One or more KEY=VALUE tags to add to your task, in addition to the iiif-process:job-id, iiif-process:uri-count and iiif-process:trigger tags.")
+
+	var group = flag.String("group", "", "An optional task group for your task. If empty the task definition's family is used.")
+
+	var callback_hosts flags.MultiString
+	flag.Var(&callback_hosts, "callback-host", "One or more hosts (or host:port pairs) that the callback URLs in job requests may point to. If none are set callbacks may be posted to any host. Used if -mode is \"lambda\", \"lambda-sqs\", \"server\" or \"worker\".")
+
+	var callback = flag.String("callback", "", "An optional HTTP(S) URL that the outcome of a job will be POSTed to (as JSON) once its task has been launched or, if -wait is true, once it has completed. Only used if -mode is \"invoke\".")
+
+	var mode = flag.String("mode", "task", "Valid modes are: lambda (run as a Lambda function triggered by S3, SNS or EventBridge), lambda-sqs (run as a Lambda function triggered by SQS messages wrapping S3 events), lambda-ecs (run as a Lambda function triggered by EventBridge ECS task state change events, to record and announce the outcome of tasks), invoke (invoke this Lambda function), server (run an HTTP API for launching and tracking tasks), worker (process messages from an SQS queue), status (print the records in -job-store for -job-id and/or one or more URIs), stop (stop one or more tasks, by task ARN or job ID), describe (describe one or more tasks, by task ARN or ID), list (list the running and recently stopped tasks in -cluster, optionally for -job-id), task (run this ECS task).")
+
+	var job_store = flag.String("job-store", "", "A URI for recording which URIs were sent to which task and how that turned out. Valid options are: file:///path/to/jobs.json, dynamodb://{TABLE}.")
+	var job_store_dsn = flag.String("job-store-dsn", "", "A valid (go-whosonfirst-aws) DynamoDB DSN. If empty the value of -ecs-dsn is used.")
 
-	var mode = flag.String("mode", "task", "Valid modes are: lambda (run as a Lambda function), invoke (invoke this Lambda function), task (run this ECS task).")
+	var notify flags.MultiString
+	flag.Var(&notify, "notify", "One or more HTTP(S) URLs or SNS topic ARNs to send the outcome of each task to if -mode is \"lambda-ecs\". SNS topics are published to using the -ecs-dsn flag.")
+
+	var sqs_dsn = flag.String("sqs-dsn", "", "A valid (go-whosonfirst-aws) SQS DSN. If empty the value of -ecs-dsn is used.")
+	var sqs_queue = flag.String("sqs-queue", "", "The URL of the SQS queue to receive messages from. Required if -mode is \"worker\".")
+	var batch_size = flag.Int("batch-size", 10, "The maximum number of SQS messages to launch tasks for at once if -mode is \"worker\".")
+	var batch_window = flag.Duration("batch-window", 30*time.Second, "The longest to wait for a batch of SQS messages to fill up, once its first message has arrived, if -mode is \"worker\".")
+	var visibility_timeout = flag.Duration("visibility-timeout", 5*time.Minute, "How long SQS messages are hidden from other consumers, if -mode is \"worker\". It is extended for as long as the messages are being processed.")
+
+	var server_address = flag.String("server-address", "localhost:8080", "The address to listen for requests on if -mode is \"server\". Ignored when running as a Lambda function (behind API Gateway or a function URL).")
 
 	var lambda_dsn = flag.String("lambda-dsn", "", "A valid (go-whosonfirst-aws) Lambda DSN. Required if -mode is \"invoke\".")
 	var lambda_func = flag.String("lambda-func", "", "A valid Lambda function name. Required if -mode is \"invoke\".")
@@ -36,6 +111,8 @@ func main() {
 	var subnets flags.MultiString
 	flag.Var(&subnets, "subnet", "One or more AWS subnets in which your task will run.")
 
+	var public_ip = flag.String("public-ip", "ENABLED", "Whether or not to assign a public IP address to your task. Valid options are: ENABLED, DISABLED. Tasks without a public IP address need to run in a subnet with a NAT gateway or VPC endpoints for the services they use.")
+
 	var security_groups flags.MultiString
 	flag.Var(&security_groups, "security-group", "One of more AWS security groups your task will assume.")
 
@@ -47,11 +124,19 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// in the stop and describe modes positional arguments are task or job IDs
+
+	args := make([]string, 0)
 	uris := make([]uri.URI, 0)
 
 	for _, str_uri := range flag.Args() {
 
-		iiif_uri, err := uri.NewURI(str_uri)
+		if *mode == "stop" || *mode == "describe" {
+			args = append(args, str_uri)
+			continue
+		}
+
+		iiif_uri, err := ecs.ParseURI(str_uri)
 
 		if err != nil {
 			log.Fatal(err)
@@ -60,7 +145,11 @@ func main() {
 		uris = append(uris, iiif_uri)
 	}
 
-	if *mode == "lambda" {
+	// server mode can also be run as a Lambda function
+
+	in_lambda := os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" || os.Getenv("_LAMBDA_SERVER_PORT") != ""
+
+	if strings.HasPrefix(*mode, "lambda") || (*mode == "server" && in_lambda) {
 
 		if *wait == true {
 			log.Println("[WARNING] -wait flag when running as a Lambda function seems to always time out, because... computers?")
@@ -82,52 +171,538 @@ func main() {
 
 		subnets = expand(subnets, ",")
 		security_groups = expand(security_groups, ",")
+		include = expand(include, ",")
+		exclude = expand(exclude, ",")
+		notify = expand(notify, ",")
+		tags = expand(tags, ",")
+		callback_hosts = expand(callback_hosts, ",")
+	}
+
+	strategy := make([]*ecs.CapacityProvider, 0)
+
+	for _, str_strategy := range capacity_providers {
+
+		providers, err := ecs.ParseCapacityProviderStrategy(str_strategy)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		strategy = append(strategy, providers...)
+	}
+
+	var policy *ecs.SizingPolicy
+
+	if *sizing_policy != "" {
+
+		p, err := ecs.NewSizingPolicyWithDSN(*ecs_dsn, *sizing_policy)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		policy = p
+	}
+
+	var sources *ecs.SourceMap
+
+	if *source_map != "" {
+
+		m, err := ecs.NewSourceMap(*source_map)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		sources = m
+	}
+
+	var filter *ecs.EventFilter
+
+	if len(derivatives_configs) > 0 || len(include) > 0 || len(exclude) > 0 {
+
+		filter = ecs.NewEventFilter()
+		filter.Include = include
+		filter.Exclude = exclude
+
+		for _, cfg := range derivatives_configs {
+
+			err := filter.AddIIIFConfig(cfg)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	retry := &ecs.RetryOptions{
+		MaxAttempts:   *max_attempts,
+		MaxElapsed:    *max_elapsed,
+		RotateSubnets: *rotate_subnets,
+	}
+
+	if *job_id != "" {
+
+		err := ecs.ValidateJobId(*job_id)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, str_uri := range []string{*config_source, *instructions_source} {
+
+		if str_uri == "" {
+			continue
+		}
+
+		err := ecs.ValidateBucketURI(str_uri)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	task_tags, err := ecs.ParseTags(tags)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var store ecs.JobStore
+
+	if *job_store != "" {
+
+		str_dsn := *job_store_dsn
+
+		if str_dsn == "" {
+			str_dsn = *ecs_dsn
+		}
+
+		s, err := ecs.NewJobStore(*job_store, str_dsn)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer s.Close()
+
+		store = s
+	}
+
+	var staging *ecs.Staging
+
+	if *staging_source != "" {
+
+		str_dsn := *staging_dsn
+
+		if str_dsn == "" {
+			str_dsn = *ecs_dsn
+		}
+
+		s, err := ecs.NewStagingWithDSN(*staging_source, *staging_prefix, str_dsn)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		s.Cleanup = *cleanup_instructions
+		staging = s
+	}
+
+	if *manifest && staging == nil {
+		log.Fatal("-manifest requires -staging-source")
+	}
+
+	var instructions_body []byte
+
+	if *instructions_document != "" {
+
+		body, err := ecs.ReadInstructionsDocument(*instructions_document)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		instructions_body = body
 	}
 
 	opts := &ecs.ProcessTaskOptions{
-		DSN:            *ecs_dsn,
-		Task:           *task,
-		Wait:           *wait,
-		Container:      *container,
-		Cluster:        *cluster,
-		Subnets:        subnets,
-		SecurityGroups: security_groups,
-		Config:         *config,
-		Report:         *report,
-		ReportName:     *report_name,
-		Instructions:   *instructions,
-		URIs:           uris,
+		DSN:                  *ecs_dsn,
+		Task:                 *task,
+		Wait:                 *wait,
+		Follow:               *follow,
+		Container:            *container,
+		LaunchType:           *launch_type,
+		CapacityProviders:    strategy,
+		PlatformVersion:      *platform_version,
+		CPU:                  *cpu,
+		Memory:               *memory,
+		SizingPolicy:         policy,
+		Retry:                retry,
+		Sources:              sources,
+		Filter:               filter,
+		Cluster:              *cluster,
+		Subnets:              subnets,
+		SecurityGroups:       security_groups,
+		AssignPublicIp:       *public_ip,
+		Config:               *config,
+		Report:               *report,
+		ReportName:           *report_name,
+		Instructions:         *instructions,
+		ConfigSource:         *config_source,
+		ConfigName:           *config_name,
+		InstructionsSource:   *instructions_source,
+		InstructionsName:     *instructions_name,
+		InstructionsDocument: instructions_body,
+		Staging:              staging,
+		Manifest:             *manifest,
+		URIs:                 uris,
+		JobId:                *job_id,
+		Callback:             *callback,
+		CallbackHosts:        callback_hosts,
+		Store:                store,
+		Group:                *group,
+		Tags:                 task_tags,
+		Trigger:              *mode,
+	}
+
+	batch_opts := &ecs.BatchOptions{
+		MaxURIsPerTask: *max_uris,
+		Concurrency:    *concurrency,
+	}
+
+	// the modes that process S3 events also skip events for the derivatives caches in the IIIF
+	// configs that tasks are launched with, if they can be read from here, so that derivatives
+	// don't trigger more tasks
+
+	switch *mode {
+	case "lambda", "lambda-sqs", "worker":
+
+		if filter == nil {
+			filter = ecs.NewEventFilter()
+			filter.Include = include
+			filter.Exclude = exclude
+			opts.Filter = filter
+		}
+
+		s3_svc, err := ecs.NewS3ServiceWithDSN(*ecs_dsn, "")
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		filter.AddTaskConfigs(context.Background(), opts, s3_svc)
+
+		if len(filter.Derivatives) == 0 {
+			log.Println("[WARNING] No derivatives cache is configured so if derivatives are written to a bucket that triggers this function they will be processed too. Use the -derivatives-config flag (or -config-source) to skip them.")
+		}
 	}
 
 	switch *mode {
 
 	case "lambda":
 
-		handler := ecs.LambdaHandlerFunc(opts)
+		handler := ecs.EventLambdaHandlerFunc(opts)
+		aws_lambda.Start(handler)
+
+	case "lambda-ecs":
+
+		notifiers := make([]ecs.Notifier, len(notify))
+
+		for i, target := range notify {
+
+			n, err := ecs.NewNotifier(target, *ecs_dsn)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			notifiers[i] = n
+		}
+
+		handler := ecs.TaskStateChangeLambdaHandlerFunc(opts, notifiers)
+		aws_lambda.Start(handler)
+
+	case "lambda-sqs":
+
+		handler := ecs.SQSLambdaHandlerFunc(opts, batch_opts)
 		aws_lambda.Start(handler)
 
 	case "invoke":
 
+		// only override the Lambda function's instructions if they
+		// were explicitly set
+
+		instructions_set := false
+
+		flag.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "instructions", "instructions-source", "instructions-name", "instructions-document":
+				instructions_set = true
+			}
+		})
+
+		if !instructions_set {
+			opts.Instructions = ""
+			opts.InstructionsSource = ""
+			opts.InstructionsName = ""
+			opts.InstructionsDocument = nil
+		}
+
 		rsp, err := ecs.InvokeLambdaHandlerFunc(opts, *lambda_dsn, *lambda_func, *lambda_type)
 
 		if err != nil {
+
+			if fn_err, ok := err.(*ecs.LambdaFunctionError); ok && fn_err.Log != "" {
+				fmt.Fprintln(os.Stderr, fn_err.Log)
+			}
+
 			log.Fatal(err)
 		}
 
-		log.Println(rsp)
+		// rsp will be nil for asynchronous invocations or if the Lambda
+		// function didn't launch a task
 
-	case "task":
+		if rsp == nil {
+			return
+		}
+
+		enc, err := json.Marshal(rsp)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(string(enc))
+
+		err = rsp.ExitError()
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "server":
+
+		l, err := ecs.NewProcessTaskLauncherWithDSN(*ecs_dsn)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if len(callback_hosts) == 0 {
+			log.Println("[WARNING] No -callback-host flags set, job requests may post their results to any host")
+		}
+
+		handler := ecs.JobsHandler(l, opts)
+
+		if in_lambda {
+			aws_lambda.Start(ecs.ProxyLambdaHandlerFunc(handler))
+			return
+		}
+
+		log.Printf("Listening for requests on %s\n", *server_address)
+
+		err = http.ListenAndServe(*server_address, handler)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "worker":
+
+		str_dsn := *sqs_dsn
+
+		if str_dsn == "" {
+			str_dsn = *ecs_dsn
+		}
+
+		worker_opts := &ecs.WorkerOptions{
+			QueueURL:          *sqs_queue,
+			BatchSize:         *batch_size,
+			BatchWindow:       *batch_window,
+			VisibilityTimeout: *visibility_timeout,
+		}
+
+		w, err := ecs.NewWorkerWithDSN(str_dsn, opts, batch_opts, worker_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
-		rsp, err := ecs.LaunchProcessTask(ctx, opts)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+		go func() {
+			sig := <-signals
+			log.Printf("Received %s, finishing current batch before exiting\n", sig)
+			cancel()
+		}()
+
+		err = w.Run(ctx)
 
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		log.Println(rsp)
+	case "status":
+
+		if store == nil {
+			log.Fatal("Missing -job-store")
+		}
+
+		if *job_id == "" && len(uris) == 0 {
+			log.Fatal("Nothing to query, please specify -job-id and/or one or more URIs")
+		}
+
+		ctx := context.Background()
+
+		records := make([]*ecs.JobRecord, 0)
+
+		if *job_id != "" {
+
+			job_records, err := store.JobRecordsForJobId(ctx, *job_id)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			records = append(records, job_records...)
+		}
+
+		for _, u := range uris {
+
+			uri_records, err := store.JobRecordsForURI(ctx, u.String())
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			records = append(records, uri_records...)
+		}
+
+		for _, r := range records {
+
+			enc, err := json.Marshal(r)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			fmt.Println(string(enc))
+		}
+
+	case "stop", "describe", "list":
+
+		if *mode != "list" && len(args) == 0 {
+			log.Fatal("Nothing to do, please specify one or more task ARNs or job IDs")
+		}
+
+		l, err := ecs.NewProcessTaskLauncherWithDSN(*ecs_dsn)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		ctx := context.Background()
+
+		emit := func(descriptions ...*ecs.TaskDescription) {
+
+			for _, d := range descriptions {
+
+				enc, err := json.Marshal(d)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				fmt.Println(string(enc))
+			}
+		}
+
+		switch *mode {
+		case "stop":
+
+			for _, id := range args {
+
+				stopped, err := l.StopProcessTasks(ctx, opts, id, "Stopped by iiif-process-ecs")
+
+				emit(stopped...)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				if len(stopped) == 0 {
+					log.Printf("No running tasks for %s\n", id)
+				}
+			}
+
+		case "describe":
+
+			for _, id := range args {
+
+				d, err := l.DescribeProcessTask(ctx, opts, id)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				emit(d)
+			}
+
+		default:
+
+			tasks, err := l.ListProcessTasks(ctx, opts, *job_id)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			emit(tasks...)
+		}
+
+	case "task":
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		rsp, err := ecs.LaunchProcessTaskBatch(ctx, opts, batch_opts)
+
+		// if -wait is true then rsp will be returned alongside any
+		// (*ecs.BatchError) error for tasks that failed
+
+		if rsp != nil {
+
+			results := make(map[string]*ecs.ProcessResult)
+
+			for _, task_rsp := range rsp.Tasks {
+
+				log.Println(task_rsp)
+
+				for k, v := range task_rsp.Results {
+					results[k] = v
+				}
+			}
+
+			if len(results) > 0 {
+
+				enc, enc_err := json.Marshal(results)
+
+				if enc_err != nil {
+					log.Fatal(enc_err)
+				}
+
+				fmt.Println(string(enc))
+			}
+		}
+
+		if err != nil {
+			log.Fatal(err)
+		}
 
 	default:
 		log.Fatal("unknown task")
@@ -0,0 +1,87 @@
+// iiif-process-manifest is the entrypoint for tasks launched with a URI manifest. It reads the
+// URIs from the manifest named by the IIIF_PROCESS_MANIFEST_SOURCE and IIIF_PROCESS_MANIFEST_NAME
+// environment variables and then runs the command it was passed with a -uri flag for each of them.
+// For example:
+//
+//	/bin/iiif-process-manifest /bin/iiif-process -config-source ... -instructions-source ...
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/go-iiif/go-iiif-aws/ecs"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var dsn = flag.String("dsn", "", "A valid (go-whosonfirst-aws) S3 DSN. If empty the default AWS credential chain (the task's role) is used with the region from the manifest source.")
+
+// manifestStaging returns the Staging for the manifest named by the environment, along with
+// the manifest's name.
+func manifestStaging(str_dsn string) (*ecs.Staging, string, error) {
+
+	source := os.Getenv(ecs.MANIFEST_SOURCE_ENV_VAR)
+	name := os.Getenv(ecs.MANIFEST_NAME_ENV_VAR)
+
+	if source == "" || name == "" {
+		msg := fmt.Sprintf("Missing %s or %s environment variable", ecs.MANIFEST_SOURCE_ENV_VAR, ecs.MANIFEST_NAME_ENV_VAR)
+		return nil, "", errors.New(msg)
+	}
+
+	staging, err := ecs.NewStagingWithDSN(source, "", str_dsn)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return staging, name, nil
+}
+
+func main() {
+
+	flag.Parse()
+
+	args := flag.Args()
+
+	if len(args) == 0 {
+		log.Fatal("Missing command")
+	}
+
+	staging, name, err := manifestStaging(*dsn)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	uris, err := staging.ReadManifest(context.Background(), name)
+
+	if err != nil {
+		log.Fatalf("Failed to read manifest %s, %s", name, err)
+	}
+
+	if len(uris) == 0 {
+		log.Fatalf("Manifest %s has no URIs", name)
+	}
+
+	for _, u := range uris {
+		args = append(args, "-uri", u)
+	}
+
+	path, err := exec.LookPath(args[0])
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// replace this process so that signals and the exit status are those of the command
+
+	err = syscall.Exec(path, args, os.Environ())
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/go-iiif/go-iiif-aws/ecs"
+	"os"
+	"testing"
+)
+
+func TestManifestStagingDefaultFlags(t *testing.T) {
+
+	os.Setenv(ecs.MANIFEST_SOURCE_ENV_VAR, "s3://example?region=us-east-1")
+	os.Setenv(ecs.MANIFEST_NAME_ENV_VAR, "iiif-process/iiif-1234/manifest-abcd.jsonl")
+
+	defer os.Unsetenv(ecs.MANIFEST_SOURCE_ENV_VAR)
+	defer os.Unsetenv(ecs.MANIFEST_NAME_ENV_VAR)
+
+	staging, name, err := manifestStaging(*dsn)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging with default flags, %s", err)
+	}
+
+	if staging.Source() != "s3://example?region=us-east-1" {
+		t.Fatalf("Unexpected staging source %s", staging.Source())
+	}
+
+	if name != "iiif-process/iiif-1234/manifest-abcd.jsonl" {
+		t.Fatalf("Unexpected manifest name %s", name)
+	}
+}
+
+func TestManifestStagingMissingEnvironment(t *testing.T) {
+
+	os.Unsetenv(ecs.MANIFEST_SOURCE_ENV_VAR)
+	os.Unsetenv(ecs.MANIFEST_NAME_ENV_VAR)
+
+	_, _, err := manifestStaging(*dsn)
+
+	if err == nil {
+		t.Fatal("Expected an error if the manifest environment variables are missing")
+	}
+}
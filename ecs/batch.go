@@ -0,0 +1,379 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/go-iiif/go-iiif-uri"
+	"strings"
+	"sync"
+)
+
+// The maximum size, in bytes, of the (JSON-encoded) overrides for a task.
+// https://docs.aws.amazon.com/AmazonECS/latest/APIReference/API_TaskOverride.html
+const MAX_OVERRIDES_SIZE int = 8192
+
+type BatchOptions struct {
+	// The maximum number of URIs to send to a single task. If 0 there is no limit.
+	MaxURIsPerTask int
+	// The maximum size, in bytes, of the (JSON-encoded) container override for a task. If 0
+	// then MAX_OVERRIDES_SIZE less 1KB (for things other than the command) is assumed.
+	MaxCommandSize int
+	// The maximum number of tasks to launch (and wait on) at the same time. If 0 then 1 is assumed.
+	Concurrency int
+}
+
+type BatchProcessTaskResponse struct {
+	Tasks []*ProcessTaskResponse
+	// A dictionary mapping each URI (as a string) to the ARN of the task processing it.
+	TaskIds map[string]string
+	// A dictionary mapping each URI (as a string) that could not be processed to an error message.
+	Errors map[string]string `json:",omitempty"`
+}
+
+func (b *BatchProcessTaskResponse) String() string {
+
+	task_ids := make([]string, len(b.Tasks))
+
+	for i, t := range b.Tasks {
+		task_ids[i] = t.TaskId
+	}
+
+	return strings.Join(task_ids, " ")
+}
+
+// Redacted returns a copy of b whose tasks and URIs have had their secrets redacted (see
+// ProcessTaskResponse.Redacted).
+func (b *BatchProcessTaskResponse) Redacted() *BatchProcessTaskResponse {
+
+	r := BatchProcessTaskResponse{
+		Tasks:   make([]*ProcessTaskResponse, len(b.Tasks)),
+		TaskIds: make(map[string]string),
+		Errors:  make(map[string]string),
+	}
+
+	for i, t := range b.Tasks {
+		r.Tasks[i] = t.Redacted()
+	}
+
+	for str_uri, task_id := range b.TaskIds {
+		r.TaskIds[RedactURI(str_uri)] = task_id
+	}
+
+	for str_uri, msg := range b.Errors {
+		r.Errors[RedactURI(str_uri)] = msg
+	}
+
+	return &r
+}
+
+type BatchError struct {
+	Count  int
+	Errors []error
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d of %d tasks failed, the first error was: %s", len(e.Errors), e.Count, e.Errors[0])
+}
+
+func LaunchProcessTaskBatch(ctx context.Context, opts *ProcessTaskOptions, batch_opts *BatchOptions) (*BatchProcessTaskResponse, error) {
+
+	l, err := NewProcessTaskLauncherWithDSN(opts.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.LaunchProcessTaskBatch(ctx, opts, batch_opts)
+}
+
+// LaunchProcessTaskBatch splits opts.URIs in to chunks (see ChunkURIs) and launches one task
+// for each chunk. If any tasks fail to launch (or fail to complete if opts.Wait is true) the
+// response is returned alongside a *BatchError. Instructions documents are staged once for
+// the whole batch (see LaunchProcessTask).
+func (l *ProcessTaskLauncher) LaunchProcessTaskBatch(ctx context.Context, opts *ProcessTaskOptions, batch_opts *BatchOptions) (*BatchProcessTaskResponse, error) {
+
+	// all the tasks in a batch share the same job ID
+
+	opts, err := withJobId(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// as do their instructions
+
+	opts, staged, err := stageInstructions(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// if there is a sizing policy then group URIs by tier so that small images
+	// and large images are not processed by the same task
+
+	groups := []*ProcessTaskOptions{
+		opts,
+	}
+
+	if opts.SizingPolicy != nil && opts.CPU == 0 && opts.Memory == 0 {
+
+		groups = make([]*ProcessTaskOptions, 0)
+
+		tiers, tier_uris := opts.SizingPolicy.GroupURIs(opts.URIs)
+
+		for i, t := range tiers {
+
+			group_opts := *opts
+			group_opts.URIs = tier_uris[i]
+			group_opts.SizingPolicy = nil
+
+			if t != nil {
+				group_opts.CPU = t.CPU
+				group_opts.Memory = t.Memory
+			}
+
+			groups = append(groups, &group_opts)
+		}
+	}
+
+	chunks := make([][]uri.URI, 0)
+	chunks_opts := make([]*ProcessTaskOptions, 0)
+
+	for _, group_opts := range groups {
+
+		group_chunks, err := ChunkURIs(group_opts, batch_opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, chunk := range group_chunks {
+
+			chunk_opts := *group_opts
+			chunk_opts.URIs = chunk
+
+			chunks = append(chunks, chunk)
+			chunks_opts = append(chunks_opts, &chunk_opts)
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil, errors.New("No images to process")
+	}
+
+	concurrency := batch_opts.Concurrency
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	responses := make([]*ProcessTaskResponse, len(chunks))
+	errs := make([]error, len(chunks))
+
+	throttle := make(chan bool, concurrency)
+	wg := new(sync.WaitGroup)
+
+	for i, chunk_opts := range chunks_opts {
+
+		wg.Add(1)
+
+		go func(i int, chunk_opts *ProcessTaskOptions) {
+
+			defer wg.Done()
+
+			select {
+			case <-ctx.Done():
+				errs[i] = ctx.Err()
+				return
+			case throttle <- true:
+				// pass
+			}
+
+			defer func() {
+				<-throttle
+			}()
+
+			rsp, err := l.LaunchProcessTask(ctx, chunk_opts)
+
+			responses[i] = rsp
+			errs[i] = err
+
+		}(i, chunk_opts)
+	}
+
+	wg.Wait()
+
+	launched := 0
+
+	for _, rsp := range responses {
+
+		if rsp != nil {
+			launched += 1
+		}
+	}
+
+	if launched == 0 || opts.Wait || opts.Follow {
+		cleanupInstructions(ctx, opts, staged)
+	}
+
+	batch_rsp := &BatchProcessTaskResponse{
+		Tasks:   make([]*ProcessTaskResponse, 0),
+		TaskIds: make(map[string]string),
+		Errors:  make(map[string]string),
+	}
+
+	batch_errs := make([]error, 0)
+
+	for i, chunk := range chunks {
+
+		rsp := responses[i]
+		err := errs[i]
+
+		if rsp != nil {
+
+			batch_rsp.Tasks = append(batch_rsp.Tasks, rsp)
+
+			for _, u := range chunk {
+				batch_rsp.TaskIds[u.String()] = rsp.TaskId
+			}
+		}
+
+		if err != nil {
+
+			batch_errs = append(batch_errs, err)
+
+			for _, u := range chunk {
+				batch_rsp.Errors[u.String()] = err.Error()
+			}
+		}
+	}
+
+	if len(batch_errs) > 0 {
+
+		batch_err := &BatchError{
+			Count:  len(chunks),
+			Errors: batch_errs,
+		}
+
+		return batch_rsp, batch_err
+	}
+
+	return batch_rsp, nil
+}
+
+// ChunkURIs splits opts.URIs in to one or more lists such that no list is longer than
+// batch_opts.MaxURIsPerTask and the encoded container override for each list is not
+// larger than batch_opts.MaxCommandSize. The order of the URIs is preserved.
+func ChunkURIs(opts *ProcessTaskOptions, batch_opts *BatchOptions) ([][]uri.URI, error) {
+
+	max_size := batch_opts.MaxCommandSize
+
+	if max_size <= 0 {
+		max_size = MAX_OVERRIDES_SIZE - 1024
+	}
+
+	chunks := make([][]uri.URI, 0)
+	chunk := make([]uri.URI, 0)
+
+	for _, u := range opts.URIs {
+
+		candidate := append(chunk[:len(chunk):len(chunk)], u)
+
+		size, err := commandSize(opts, candidate)
+
+		if err != nil {
+			return nil, err
+		}
+
+		is_full := batch_opts.MaxURIsPerTask > 0 && len(candidate) > batch_opts.MaxURIsPerTask
+
+		if len(chunk) > 0 && (is_full || size > max_size) {
+
+			chunks = append(chunks, chunk)
+			candidate = []uri.URI{u}
+
+			size, err = commandSize(opts, candidate)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if size > max_size {
+			msg := fmt.Sprintf("Command for %s exceeds maximum size of %d bytes", u.String(), max_size)
+			return nil, errors.New(msg)
+		}
+
+		chunk = candidate
+	}
+
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	if len(chunks) == 0 {
+		return nil, errors.New("No images to process")
+	}
+
+	return chunks, nil
+}
+
+// ValidateCommandSize ensures that the command for all of opts.URIs fits in the container override
+// for a single task (see ChunkURIs) and that it is otherwise valid (see ProcessCommand). It is used
+// to reject requests that would launch exactly one task up front, rather than when ECS fails to
+// launch it.
+func ValidateCommandSize(opts *ProcessTaskOptions) error {
+
+	max_size := MAX_OVERRIDES_SIZE - 1024
+
+	// instructions documents are staged when the task is launched
+
+	check_opts := *opts
+
+	if len(opts.InstructionsDocument) > 0 && opts.Staging != nil {
+		check_opts.InstructionsSource = opts.Staging.Source()
+		check_opts.InstructionsName = opts.Staging.InstructionsName(opts.JobId, opts.InstructionsDocument)
+	}
+
+	size, err := commandSize(&check_opts, opts.URIs)
+
+	if err != nil {
+		return err
+	}
+
+	if size > max_size {
+		msg := fmt.Sprintf("Command for %d URIs is %d bytes, which exceeds the maximum size of %d bytes for a single task. Split the URIs across more jobs or pass them in a manifest.", len(opts.URIs), size, max_size)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+func commandSize(opts *ProcessTaskOptions, uris []uri.URI) (int, error) {
+
+	chunk_opts := *opts
+	chunk_opts.URIs = uris
+
+	cmd, err := ProcessCommand(&chunk_opts)
+
+	if err != nil {
+		return 0, err
+	}
+
+	override := &aws_ecs.ContainerOverride{
+		Name:    aws.String(opts.Container),
+		Command: cmd,
+	}
+
+	enc, err := json.Marshal(override)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(enc), nil
+}
@@ -0,0 +1,153 @@
+package ecs
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChunkURIs(t *testing.T) {
+
+	uris := make([]string, 10)
+
+	for i := 0; i < len(uris); i++ {
+		uris[i] = fmt.Sprintf("file:///%03d.jpg", i)
+	}
+
+	opts := newTestProcessTaskOptions(t, uris...)
+
+	one_size, err := commandSize(opts, opts.URIs[:1])
+
+	if err != nil {
+		t.Fatalf("Failed to determine command size, %s", err)
+	}
+
+	two_size, err := commandSize(opts, opts.URIs[:2])
+
+	if err != nil {
+		t.Fatalf("Failed to determine command size, %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		batch_opts *BatchOptions
+		expected   []int
+	}{
+		{"defaults", &BatchOptions{}, []int{10}},
+		{"max URIs", &BatchOptions{MaxURIsPerTask: 4}, []int{4, 4, 2}},
+		{"max URIs equal to count", &BatchOptions{MaxURIsPerTask: 10}, []int{10}},
+		{"max command size", &BatchOptions{MaxCommandSize: two_size}, []int{2, 2, 2, 2, 2}},
+		{"max command size for one", &BatchOptions{MaxCommandSize: one_size}, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+		{"max URIs and command size", &BatchOptions{MaxURIsPerTask: 1, MaxCommandSize: two_size}, []int{1, 1, 1, 1, 1, 1, 1, 1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			chunks, err := ChunkURIs(opts, tt.batch_opts)
+
+			if err != nil {
+				t.Fatalf("Failed to chunk URIs, %s", err)
+			}
+
+			if len(chunks) != len(tt.expected) {
+				t.Fatalf("Expected %d chunks, got %d", len(tt.expected), len(chunks))
+			}
+
+			i := 0
+
+			for j, chunk := range chunks {
+
+				if len(chunk) != tt.expected[j] {
+					t.Fatalf("Expected chunk %d to have %d URIs, got %d", j, tt.expected[j], len(chunk))
+				}
+
+				// the order of the URIs is preserved
+
+				for _, u := range chunk {
+
+					if u.String() != uris[i] {
+						t.Fatalf("Expected URI %d to be %s, got %s", i, uris[i], u.String())
+					}
+
+					i += 1
+				}
+			}
+		})
+	}
+}
+
+func TestChunkURIsErrors(t *testing.T) {
+
+	opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+
+	_, err := ChunkURIs(opts, &BatchOptions{MaxCommandSize: 10})
+
+	if err == nil || !strings.Contains(err.Error(), "exceeds maximum size") {
+		t.Fatalf("Expected command to be too large, got %v", err)
+	}
+
+	opts = newTestProcessTaskOptions(t)
+
+	_, err = ChunkURIs(opts, &BatchOptions{})
+
+	if err == nil {
+		t.Fatal("Expected an error for no URIs")
+	}
+}
+
+func TestValidateCommandSize(t *testing.T) {
+
+	many := make([]string, 300)
+
+	for i := range many {
+		many[i] = fmt.Sprintf("file:///images/image-%04d.jpg", i)
+	}
+
+	staging, err := NewStaging(newFakeS3Service(), "s3://staging", DEFAULT_STAGING_PREFIX)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging, %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		uris     []string
+		manifest bool
+		document bool
+		valid    bool
+	}{
+		{"one", []string{"file:///zuber.jpg"}, false, false, true},
+		{"too many", many, false, false, false},
+		{"too many in a manifest", many, true, false, true},
+		{"staged instructions", []string{"file:///zuber.jpg"}, false, true, true},
+		{"not an image", []string{"file:///zuber.txt"}, false, false, false},
+		{"none", []string{}, false, false, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			opts := newTestProcessTaskOptions(t, tt.uris...)
+			opts.Manifest = tt.manifest
+			opts.Staging = staging
+
+			if tt.document {
+				opts.Instructions = ""
+				opts.InstructionsDocument = []byte(`{"o": {"size": "full"}}`)
+			}
+
+			err := ValidateCommandSize(opts)
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected command to be valid, %s", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatal("Expected command to be invalid")
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"strconv"
+	"strings"
+)
+
+const CAPACITY_PROVIDER_FARGATE string = "FARGATE"
+
+const CAPACITY_PROVIDER_FARGATE_SPOT string = "FARGATE_SPOT"
+
+type CapacityProvider struct {
+	Name   string
+	Weight int64
+	Base   int64
+}
+
+// ParseCapacityProviderStrategy parses one or more comma-separated capacity providers, each
+// in the form of "{NAME}[:{WEIGHT}[:{BASE}]]" (for example "FARGATE_SPOT:3,FARGATE:1:1").
+// Weight defaults to 1 and base to 0.
+func ParseCapacityProviderStrategy(str_strategy string) ([]*CapacityProvider, error) {
+
+	strategy := make([]*CapacityProvider, 0)
+
+	for _, str_provider := range strings.Split(str_strategy, ",") {
+
+		str_provider = strings.TrimSpace(str_provider)
+
+		if str_provider == "" {
+			continue
+		}
+
+		parts := strings.Split(str_provider, ":")
+
+		if len(parts) > 3 || parts[0] == "" {
+			msg := fmt.Sprintf("Invalid capacity provider '%s'", str_provider)
+			return nil, errors.New(msg)
+		}
+
+		p := CapacityProvider{
+			Name:   parts[0],
+			Weight: 1,
+			Base:   0,
+		}
+
+		if len(parts) > 1 {
+
+			weight, err := strconv.ParseInt(parts[1], 10, 64)
+
+			if err != nil || weight < 0 || weight > 1000 {
+				msg := fmt.Sprintf("Invalid weight for capacity provider '%s'", str_provider)
+				return nil, errors.New(msg)
+			}
+
+			p.Weight = weight
+		}
+
+		if len(parts) > 2 {
+
+			base, err := strconv.ParseInt(parts[2], 10, 64)
+
+			if err != nil || base < 0 || base > 100000 {
+				msg := fmt.Sprintf("Invalid base for capacity provider '%s'", str_provider)
+				return nil, errors.New(msg)
+			}
+
+			p.Base = base
+		}
+
+		strategy = append(strategy, &p)
+	}
+
+	return strategy, nil
+}
+
+// launchConfiguration works out the launch type or capacity provider strategy for a task
+// and whether that task will run on Fargate (which is the only place you can assign a
+// public IP address). If neither are set then tasks are launched with FARGATE, as they
+// always have been. As a convenience a launch type of FARGATE_SPOT is treated as a single
+// FARGATE_SPOT capacity provider.
+func launchConfiguration(opts *ProcessTaskOptions) (*string, []*aws_ecs.CapacityProviderStrategyItem, bool, error) {
+
+	launch_type := opts.LaunchType
+	providers := opts.CapacityProviders
+
+	if launch_type != "" && len(providers) > 0 {
+		return nil, nil, false, errors.New("Launch type and capacity providers are mutually exclusive")
+	}
+
+	if launch_type == CAPACITY_PROVIDER_FARGATE_SPOT {
+
+		launch_type = ""
+
+		providers = []*CapacityProvider{
+			&CapacityProvider{
+				Name:   CAPACITY_PROVIDER_FARGATE_SPOT,
+				Weight: 1,
+			},
+		}
+	}
+
+	if len(providers) == 0 {
+
+		if launch_type == "" {
+			launch_type = aws_ecs.LaunchTypeFargate
+		}
+
+		switch launch_type {
+		case aws_ecs.LaunchTypeFargate, aws_ecs.LaunchTypeEc2:
+			// pass
+		default:
+			msg := fmt.Sprintf("Invalid launch type '%s'", launch_type)
+			return nil, nil, false, errors.New(msg)
+		}
+
+		is_fargate := launch_type == aws_ecs.LaunchTypeFargate
+		return aws.String(launch_type), nil, is_fargate, nil
+	}
+
+	strategy := make([]*aws_ecs.CapacityProviderStrategyItem, len(providers))
+	is_fargate := true
+
+	for i, p := range providers {
+
+		switch p.Name {
+		case CAPACITY_PROVIDER_FARGATE, CAPACITY_PROVIDER_FARGATE_SPOT:
+			// pass
+		default:
+			is_fargate = false
+		}
+
+		strategy[i] = &aws_ecs.CapacityProviderStrategyItem{
+			CapacityProvider: aws.String(p.Name),
+			Weight:           aws.Int64(p.Weight),
+			Base:             aws.Int64(p.Base),
+		}
+	}
+
+	return nil, strategy, is_fargate, nil
+}
@@ -0,0 +1,58 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// readJSONDocument returns the body of str_doc, which is either a JSON-encoded object or the
+// path to a file containing one. Anything that doesn't start with "{" is assumed to be a path.
+// The body must decode to a JSON object, rather than for example null or an array, so that
+// callers can decode it in to a pointer without checking for nil. kind is used to describe
+// the document in errors, for example "source map".
+func readJSONDocument(str_doc string, kind string) ([]byte, error) {
+
+	body := []byte(str_doc)
+
+	if !strings.HasPrefix(strings.TrimSpace(str_doc), "{") {
+
+		b, err := ioutil.ReadFile(str_doc)
+
+		if err != nil {
+			return nil, err
+		}
+
+		body = b
+	}
+
+	err := checkJSONObject(body, kind)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
+
+// checkJSONObject ensures that body decodes to a JSON object (see readJSONDocument).
+func checkJSONObject(body []byte, kind string) error {
+
+	var obj map[string]json.RawMessage
+
+	err := json.Unmarshal(body, &obj)
+
+	if err != nil {
+		msg := fmt.Sprintf("Invalid %s, %s", kind, err)
+		return errors.New(msg)
+	}
+
+	if obj == nil {
+		msg := fmt.Sprintf("Invalid %s, expected a JSON object", kind)
+		return errors.New(msg)
+	}
+
+	return nil
+}
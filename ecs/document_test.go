@@ -0,0 +1,91 @@
+package ecs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadJSONDocument(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "document")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	write := func(name string, body string) string {
+
+		path := filepath.Join(dir, name)
+
+		err := ioutil.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %s", path, err)
+		}
+
+		return path
+	}
+
+	tests := []struct {
+		name  string
+		doc   string
+		valid bool
+	}{
+		{"inline", `{"hello": "world"}`, true},
+		{"inline with whitespace", ` {"hello": "world"}`, true},
+		{"file", write("object.json", `{"hello": "world"}`), true},
+		{"file null", write("null.json", `null`), false},
+		{"file array", write("array.json", `["hello", "world"]`), false},
+		{"file invalid", write("invalid.json", `{"hello":`), false},
+		{"inline invalid", `{"hello":`, false},
+		{"missing file", filepath.Join(dir, "missing.json"), false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			body, err := readJSONDocument(tt.doc, "document")
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected %s to be valid, %s", tt.doc, err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatalf("Expected %s to be invalid, got %s", tt.doc, string(body))
+			}
+		})
+	}
+
+	// these used to dereference a nil pointer when the document was null
+
+	null := write("null-document.json", "null")
+
+	_, err = NewSourceMap(null)
+
+	if err == nil {
+		t.Fatal("Expected a null source map to be invalid")
+	}
+
+	_, err = NewSizingPolicy(nil, null)
+
+	if err == nil {
+		t.Fatal("Expected a null sizing policy to be invalid")
+	}
+
+	err = NewEventFilter().AddIIIFConfig(null)
+
+	if err == nil {
+		t.Fatal("Expected a null IIIF config to be invalid")
+	}
+
+	_, err = ReadInstructionsDocument(null)
+
+	if err == nil {
+		t.Fatal("Expected a null instructions document to be invalid")
+	}
+}
@@ -0,0 +1,209 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"log"
+)
+
+const EVENT_SOURCE_S3 string = "aws:s3"
+
+const EVENT_SOURCE_SNS string = "aws:sns"
+
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/ev-events.html
+
+type EventBridgeS3Detail struct {
+	Bucket struct {
+		Name string `json:"name"`
+	} `json:"bucket"`
+	Object struct {
+		Key       string `json:"key"`
+		Size      int64  `json:"size"`
+		ETag      string `json:"etag"`
+		VersionID string `json:"version-id"`
+		Sequencer string `json:"sequencer"`
+	} `json:"object"`
+	RequestID string `json:"request-id"`
+	Requester string `json:"requester"`
+	Reason    string `json:"reason"`
+}
+
+// snsNotification is the body of an SNS notification delivered to an SQS queue (or an
+// HTTP endpoint) without raw message delivery enabled.
+type snsNotification struct {
+	Type     string `json:"Type"`
+	TopicArn string `json:"TopicArn"`
+	Message  string `json:"Message"`
+}
+
+type eventEnvelope struct {
+	Records []struct {
+		EventSource      string `json:"eventSource"`
+		EventSourceUpper string `json:"EventSource"`
+	} `json:"Records"`
+	DetailType string `json:"detail-type"`
+	Source     string `json:"source"`
+	Type       string `json:"Type"`
+	Event      string `json:"Event"`
+}
+
+// NormalizeEvent returns an S3Event for a JSON-encoded payload which may be an S3Event, an
+// SNSEvent whose messages are S3 events, an SNS notification whose message is an S3 event
+// or an EventBridge "Object Created" event for S3. S3 test events and EventBridge events
+// for other kinds of S3 activity yield an S3Event with no records.
+func NormalizeEvent(payload []byte) (aws_events.S3Event, error) {
+
+	s3_ev := aws_events.S3Event{
+		Records: make([]aws_events.S3EventRecord, 0),
+	}
+
+	var env eventEnvelope
+
+	err := json.Unmarshal(payload, &env)
+
+	if err != nil {
+		return s3_ev, err
+	}
+
+	switch {
+
+	case env.Event == "s3:TestEvent":
+
+		return s3_ev, nil
+
+	case env.Source == "aws.s3" && env.DetailType != "":
+
+		if env.DetailType != "Object Created" {
+			log.Printf("Skipping EventBridge '%s' event\n", env.DetailType)
+			return s3_ev, nil
+		}
+
+		var cw_ev aws_events.CloudWatchEvent
+
+		err := json.Unmarshal(payload, &cw_ev)
+
+		if err != nil {
+			return s3_ev, err
+		}
+
+		var detail EventBridgeS3Detail
+
+		err = json.Unmarshal(cw_ev.Detail, &detail)
+
+		if err != nil {
+			return s3_ev, err
+		}
+
+		// object keys in EventBridge events are not URL-encoded, unlike
+		// S3 event notifications, so we set URLDecodedKey explicitly
+
+		r := aws_events.S3EventRecord{
+			EventSource: EVENT_SOURCE_S3,
+			EventName:   "ObjectCreated:" + detail.Reason,
+			AWSRegion:   cw_ev.Region,
+			EventTime:   cw_ev.Time,
+		}
+
+		r.S3.Bucket.Name = detail.Bucket.Name
+		r.S3.Object.Key = detail.Object.Key
+		r.S3.Object.URLDecodedKey = detail.Object.Key
+		r.S3.Object.Size = detail.Object.Size
+		r.S3.Object.ETag = detail.Object.ETag
+		r.S3.Object.VersionID = detail.Object.VersionID
+		r.S3.Object.Sequencer = detail.Object.Sequencer
+
+		s3_ev.Records = append(s3_ev.Records, r)
+		return s3_ev, nil
+
+	case env.Type == "Notification":
+
+		var n snsNotification
+
+		err := json.Unmarshal(payload, &n)
+
+		if err != nil {
+			return s3_ev, err
+		}
+
+		return NormalizeEvent([]byte(n.Message))
+
+	case len(env.Records) > 0 && env.Records[0].EventSourceUpper == EVENT_SOURCE_SNS:
+
+		var sns_ev aws_events.SNSEvent
+
+		err := json.Unmarshal(payload, &sns_ev)
+
+		if err != nil {
+			return s3_ev, err
+		}
+
+		for _, r := range sns_ev.Records {
+
+			msg_ev, err := NormalizeEvent([]byte(r.SNS.Message))
+
+			if err != nil {
+				msg := fmt.Sprintf("Failed to parse SNS message %s: %s", r.SNS.MessageID, err)
+				return s3_ev, errors.New(msg)
+			}
+
+			s3_ev.Records = append(s3_ev.Records, msg_ev.Records...)
+		}
+
+		return s3_ev, nil
+
+	case env.Records != nil:
+
+		err := json.Unmarshal(payload, &s3_ev)
+
+		if err != nil {
+			return s3_ev, err
+		}
+
+		for _, r := range s3_ev.Records {
+
+			if r.EventSource != "" && r.EventSource != EVENT_SOURCE_S3 {
+				msg := fmt.Sprintf("Unsupported event source '%s'", r.EventSource)
+				return s3_ev, errors.New(msg)
+			}
+		}
+
+		return s3_ev, nil
+
+	default:
+		return s3_ev, errors.New("Unrecognized event")
+	}
+}
+
+// EventLambdaHandlerFunc returns a Lambda handler that accepts either a JobRequest or any of
+// the events understood by NormalizeEvent and launches tasks for the URIs they reference.
+func EventLambdaHandlerFunc(opts *ProcessTaskOptions) func(ctx context.Context, payload json.RawMessage) (*ProcessTaskResponse, error) {
+
+	handler := func(ctx context.Context, payload json.RawMessage) (*ProcessTaskResponse, error) {
+
+		if IsJobRequest(payload) {
+
+			var job *JobRequest
+
+			err := json.Unmarshal(payload, &job)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return launchJob(ctx, opts, job)
+		}
+
+		ev, err := NormalizeEvent(payload)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return launchS3Event(ctx, opts, ev)
+	}
+
+	return handler
+}
@@ -0,0 +1,84 @@
+package ecs
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const testS3Event string = `{"Records":[{"eventSource":"aws:s3","eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"example"},"object":{"key":"my+photo%281%29.jpg","size":1024}}}]}`
+
+func TestNormalizeEvent(t *testing.T) {
+
+	enc_s3_ev, err := json.Marshal(testS3Event)
+
+	if err != nil {
+		t.Fatalf("Failed to encode event, %s", err)
+	}
+
+	sns_notification := `{"Type":"Notification","TopicArn":"arn:aws:sns:us-east-1:000000000000:example","Message":` + string(enc_s3_ev) + `}`
+
+	sns_ev := `{"Records":[{"EventSource":"aws:sns","Sns":{"MessageId":"1","Message":` + string(enc_s3_ev) + `}},{"EventSource":"aws:sns","Sns":{"MessageId":"2","Message":` + string(enc_s3_ev) + `}}]}`
+
+	eventbridge_ev := `{"version":"0","id":"1","detail-type":"Object Created","source":"aws.s3","region":"us-east-1","time":"2019-12-09T21:10:03Z","detail":{"bucket":{"name":"example"},"object":{"key":"my photo(1).jpg","size":1024},"reason":"PutObject"}}`
+
+	eventbridge_deleted_ev := `{"version":"0","id":"1","detail-type":"Object Deleted","source":"aws.s3","region":"us-east-1","time":"2019-12-09T21:10:03Z","detail":{"bucket":{"name":"example"},"object":{"key":"my photo(1).jpg"}}}`
+
+	tests := []struct {
+		name    string
+		payload string
+		records int
+		ok      bool
+	}{
+		{"s3", testS3Event, 1, true},
+		{"s3 test event", `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"example"}`, 0, true},
+		{"sns notification", sns_notification, 1, true},
+		{"sns event", sns_ev, 2, true},
+		{"eventbridge", eventbridge_ev, 1, true},
+		{"eventbridge object deleted", eventbridge_deleted_ev, 0, true},
+		{"unsupported event source", `{"Records":[{"eventSource":"aws:dynamodb"}]}`, 0, false},
+		{"unrecognized", `{"hello":"world"}`, 0, false},
+		{"invalid", `{"Records":`, 0, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			s3_ev, err := NormalizeEvent([]byte(tt.payload))
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatal("Expected event to be invalid")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to normalize event, %s", err)
+			}
+
+			if len(s3_ev.Records) != tt.records {
+				t.Fatalf("Expected %d records, got %d", tt.records, len(s3_ev.Records))
+			}
+
+			for _, r := range s3_ev.Records {
+
+				if r.S3.Bucket.Name != "example" {
+					t.Fatalf("Unexpected bucket '%s'", r.S3.Bucket.Name)
+				}
+
+				key, err := S3RecordKey(r)
+
+				if err != nil {
+					t.Fatalf("Failed to decode key, %s", err)
+				}
+
+				if key != "my photo(1).jpg" {
+					t.Fatalf("Unexpected key '%s'", key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,518 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const FAKE_LOG_GROUP string = "/ecs/go-iiif-process-ecs"
+
+const FAKE_LOG_STREAM_PREFIX string = "ecs"
+
+// FakeECSService is an in-memory ECSService for running the launch and wait code paths
+// without AWS credentials. Every call to RunTask is recorded in RunTaskInputs. Tasks start
+// out PENDING and each call to DescribeTasks advances them one step towards STOPPED;
+// WaitUntilTasksStopped (and WaitUntilTasksStoppedWithContext, unless its context has been
+// cancelled) moves them straight to STOPPED. Exit codes are assigned in the
+// order tasks are launched from ExitCodes, falling back to 0 when the list is exhausted.
+// Task definitions that are not present in TaskDefinitions are described as having an
+// awslogs log configuration (see FAKE_LOG_GROUP and FAKE_LOG_STREAM_PREFIX) for each
+// container that was overridden when the task was launched. Failures in RunTaskFailures
+// are consumed, one per call to RunTask, and reported instead of launching a task until the
+// list is exhausted.
+type FakeECSService struct {
+	RunTaskInputs   []*aws_ecs.RunTaskInput
+	RunTaskFailures []string
+	ExitCodes       []int64
+	StoppedReason   string
+	TaskDefinitions map[string]*aws_ecs.TaskDefinition
+	mu              *sync.Mutex
+	tasks           map[string]*aws_ecs.Task
+	exit_codes      map[string]int64
+	count           int
+}
+
+func NewFakeECSService() *FakeECSService {
+
+	svc := FakeECSService{
+		RunTaskInputs:   make([]*aws_ecs.RunTaskInput, 0),
+		ExitCodes:       make([]int64, 0),
+		StoppedReason:   "Essential container in task exited",
+		TaskDefinitions: make(map[string]*aws_ecs.TaskDefinition),
+		mu:              new(sync.Mutex),
+		tasks:           make(map[string]*aws_ecs.Task),
+		exit_codes:      make(map[string]int64),
+		count:           0,
+	}
+
+	return &svc
+}
+
+func (svc *FakeECSService) RunTask(input *aws_ecs.RunTaskInput) (*aws_ecs.RunTaskOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	// record a copy since callers may modify input between retries
+
+	svc.RunTaskInputs = append(svc.RunTaskInputs, awsutil.CopyOf(input).(*aws_ecs.RunTaskInput))
+
+	if len(svc.RunTaskFailures) > 0 {
+
+		reason := svc.RunTaskFailures[0]
+		svc.RunTaskFailures = svc.RunTaskFailures[1:]
+
+		rsp := &aws_ecs.RunTaskOutput{
+			Tasks: []*aws_ecs.Task{},
+			Failures: []*aws_ecs.Failure{
+				&aws_ecs.Failure{
+					Reason: aws.String(reason),
+				},
+			},
+		}
+
+		return rsp, nil
+	}
+
+	exit_code := int64(0)
+
+	if svc.count < len(svc.ExitCodes) {
+		exit_code = svc.ExitCodes[svc.count]
+	}
+
+	svc.count += 1
+
+	cluster := aws.StringValue(input.Cluster)
+
+	if cluster == "" {
+		cluster = "default"
+	}
+
+	arn := fmt.Sprintf("arn:aws:ecs:us-east-1:000000000000:task/%s/%032d", cluster, svc.count)
+
+	containers := make([]*aws_ecs.Container, 0)
+
+	if input.Overrides != nil {
+
+		for _, o := range input.Overrides.ContainerOverrides {
+
+			c := &aws_ecs.Container{
+				Name:       o.Name,
+				TaskArn:    aws.String(arn),
+				LastStatus: aws.String(TASK_STATUS_PENDING),
+			}
+
+			containers = append(containers, c)
+		}
+	}
+
+	now := time.Now()
+
+	task := &aws_ecs.Task{
+		TaskArn:           aws.String(arn),
+		ClusterArn:        aws.String(cluster),
+		TaskDefinitionArn: input.TaskDefinition,
+		LaunchType:        input.LaunchType,
+		StartedBy:         input.StartedBy,
+		Group:             input.Group,
+		Tags:              input.Tags,
+		Overrides:         input.Overrides,
+		Containers:        containers,
+		CreatedAt:         aws.Time(now),
+		LastStatus:        aws.String(TASK_STATUS_PENDING),
+		DesiredStatus:     aws.String(TASK_STATUS_RUNNING),
+	}
+
+	svc.tasks[arn] = task
+	svc.exit_codes[arn] = exit_code
+
+	rsp := &aws_ecs.RunTaskOutput{
+		Tasks: []*aws_ecs.Task{
+			copyTask(task),
+		},
+	}
+
+	return rsp, nil
+}
+
+// task returns the task (and its ARN) for id, which may be either a task ARN or a task ID
+// as it is for ECS itself.
+func (svc *FakeECSService) task(id string) (string, *aws_ecs.Task, bool) {
+
+	task, ok := svc.tasks[id]
+
+	if ok {
+		return id, task, true
+	}
+
+	for arn, task := range svc.tasks {
+
+		if TaskIdFromArn(arn) == id {
+			return arn, task, true
+		}
+	}
+
+	return id, nil, false
+}
+
+func (svc *FakeECSService) DescribeTasks(input *aws_ecs.DescribeTasksInput) (*aws_ecs.DescribeTasksOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	tasks := make([]*aws_ecs.Task, 0)
+	failures := make([]*aws_ecs.Failure, 0)
+
+	for _, ptr_arn := range input.Tasks {
+
+		arn, task, ok := svc.task(aws.StringValue(ptr_arn))
+
+		if !ok {
+
+			f := &aws_ecs.Failure{
+				Arn:    aws.String(arn),
+				Reason: aws.String("MISSING"),
+			}
+
+			failures = append(failures, f)
+			continue
+		}
+
+		switch aws.StringValue(task.LastStatus) {
+		case TASK_STATUS_PENDING:
+			svc.setStatus(task, TASK_STATUS_RUNNING, "")
+		case TASK_STATUS_RUNNING:
+			svc.setStatus(task, TASK_STATUS_STOPPED, svc.StoppedReason)
+		default:
+			// pass
+		}
+
+		tasks = append(tasks, copyTask(task))
+	}
+
+	rsp := &aws_ecs.DescribeTasksOutput{
+		Tasks:    tasks,
+		Failures: failures,
+	}
+
+	return rsp, nil
+}
+
+func (svc *FakeECSService) ListTasks(input *aws_ecs.ListTasksInput) (*aws_ecs.ListTasksOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	cluster := aws.StringValue(input.Cluster)
+
+	if cluster == "" {
+		cluster = "default"
+	}
+
+	desired_status := aws.StringValue(input.DesiredStatus)
+
+	if desired_status == "" {
+		desired_status = TASK_STATUS_RUNNING
+	}
+
+	family := aws.StringValue(input.Family)
+	started_by := aws.StringValue(input.StartedBy)
+
+	arns := make([]*string, 0)
+
+	for i := 1; i <= svc.count; i++ {
+
+		arn := fmt.Sprintf("arn:aws:ecs:us-east-1:000000000000:task/%s/%032d", cluster, i)
+		task, ok := svc.tasks[arn]
+
+		if !ok {
+			continue
+		}
+
+		if aws.StringValue(task.DesiredStatus) != desired_status {
+			continue
+		}
+
+		if started_by != "" && aws.StringValue(task.StartedBy) != started_by {
+			continue
+		}
+
+		if family != "" && taskDefinitionFamily(aws.StringValue(task.TaskDefinitionArn)) != family {
+			continue
+		}
+
+		arns = append(arns, aws.String(arn))
+	}
+
+	rsp := &aws_ecs.ListTasksOutput{
+		TaskArns: arns,
+	}
+
+	return rsp, nil
+}
+
+func (svc *FakeECSService) StopTask(input *aws_ecs.StopTaskInput) (*aws_ecs.StopTaskOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	arn, task, ok := svc.task(aws.StringValue(input.Task))
+
+	if !ok {
+		msg := fmt.Sprintf("Unknown task %s", arn)
+		return nil, errors.New(msg)
+	}
+
+	if aws.StringValue(task.LastStatus) != TASK_STATUS_STOPPED {
+
+		reason := aws.StringValue(input.Reason)
+
+		if reason == "" {
+			reason = "Task stopped by user"
+		}
+
+		// this is what ECS reports for containers that are sent SIGKILL
+
+		svc.exit_codes[arn] = 137
+		svc.setStatus(task, TASK_STATUS_STOPPED, reason)
+	}
+
+	rsp := &aws_ecs.StopTaskOutput{
+		Task: copyTask(task),
+	}
+
+	return rsp, nil
+}
+
+func (svc *FakeECSService) WaitUntilTasksStopped(input *aws_ecs.DescribeTasksInput) error {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	for _, ptr_arn := range input.Tasks {
+
+		arn, task, ok := svc.task(aws.StringValue(ptr_arn))
+
+		if !ok {
+			msg := fmt.Sprintf("Unknown task %s", arn)
+			return errors.New(msg)
+		}
+
+		if aws.StringValue(task.LastStatus) != TASK_STATUS_STOPPED {
+			svc.setStatus(task, TASK_STATUS_STOPPED, svc.StoppedReason)
+		}
+	}
+
+	return nil
+}
+
+func (svc *FakeECSService) WaitUntilTasksStoppedWithContext(ctx aws.Context, input *aws_ecs.DescribeTasksInput, opts ...request.WaiterOption) error {
+
+	// like the AWS SDK, a cancelled context is reported as a request.CanceledErrorCode error
+
+	if ctx.Err() != nil {
+		return awserr.New(request.CanceledErrorCode, "waiter context canceled", ctx.Err())
+	}
+
+	return svc.WaitUntilTasksStopped(input)
+}
+
+func (svc *FakeECSService) DescribeTaskDefinition(input *aws_ecs.DescribeTaskDefinitionInput) (*aws_ecs.DescribeTaskDefinitionOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	name := aws.StringValue(input.TaskDefinition)
+
+	def, ok := svc.TaskDefinitions[name]
+
+	if !ok {
+
+		containers := make([]*aws_ecs.ContainerDefinition, 0)
+		seen := make(map[string]bool)
+
+		for _, i := range svc.RunTaskInputs {
+
+			if aws.StringValue(i.TaskDefinition) != name || i.Overrides == nil {
+				continue
+			}
+
+			for _, o := range i.Overrides.ContainerOverrides {
+
+				container := aws.StringValue(o.Name)
+
+				if seen[container] {
+					continue
+				}
+
+				seen[container] = true
+
+				c := &aws_ecs.ContainerDefinition{
+					Name: aws.String(container),
+					LogConfiguration: &aws_ecs.LogConfiguration{
+						LogDriver: aws.String(aws_ecs.LogDriverAwslogs),
+						Options: map[string]*string{
+							"awslogs-group":         aws.String(FAKE_LOG_GROUP),
+							"awslogs-stream-prefix": aws.String(FAKE_LOG_STREAM_PREFIX),
+						},
+					},
+				}
+
+				containers = append(containers, c)
+			}
+		}
+
+		def = &aws_ecs.TaskDefinition{
+			TaskDefinitionArn:    aws.String(name),
+			ContainerDefinitions: containers,
+		}
+	}
+
+	rsp := &aws_ecs.DescribeTaskDefinitionOutput{
+		TaskDefinition: def,
+	}
+
+	return rsp, nil
+}
+
+func (svc *FakeECSService) setStatus(task *aws_ecs.Task, status string, reason string) {
+
+	now := time.Now()
+	arn := aws.StringValue(task.TaskArn)
+
+	task.LastStatus = aws.String(status)
+
+	for _, c := range task.Containers {
+		c.LastStatus = aws.String(status)
+	}
+
+	switch status {
+	case TASK_STATUS_RUNNING:
+
+		task.StartedAt = aws.Time(now)
+
+	case TASK_STATUS_STOPPED:
+
+		if task.StartedAt == nil {
+			task.StartedAt = aws.Time(now)
+		}
+
+		task.DesiredStatus = aws.String(TASK_STATUS_STOPPED)
+		task.StoppingAt = aws.Time(now)
+		task.StoppedAt = aws.Time(now)
+		task.StoppedReason = aws.String(reason)
+
+		for _, c := range task.Containers {
+			c.ExitCode = aws.Int64(svc.exit_codes[arn])
+		}
+	}
+}
+
+func copyTask(task *aws_ecs.Task) *aws_ecs.Task {
+
+	t := *task
+	t.Containers = make([]*aws_ecs.Container, len(task.Containers))
+
+	for i, c := range task.Containers {
+		c2 := *c
+		t.Containers[i] = &c2
+	}
+
+	return &t
+}
+
+// FakeLogsService is an in-memory LogsService. Use AddLogEvents to populate a log stream,
+// for example with the output you would expect iiif-process to emit for a task launched
+// by FakeECSService (whose streams are named FAKE_LOG_STREAM_PREFIX/{CONTAINER}/{TASK_ID}).
+type FakeLogsService struct {
+	PageSize int
+	mu       *sync.Mutex
+	events   map[string][]*cloudwatchlogs.OutputLogEvent
+}
+
+func NewFakeLogsService() *FakeLogsService {
+
+	svc := FakeLogsService{
+		PageSize: 100,
+		mu:       new(sync.Mutex),
+		events:   make(map[string][]*cloudwatchlogs.OutputLogEvent),
+	}
+
+	return &svc
+}
+
+func (svc *FakeLogsService) AddLogEvents(group string, stream string, messages ...string) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	key := fmt.Sprintf("%s#%s", group, stream)
+
+	for _, m := range messages {
+
+		now := time.Now().UnixNano() / int64(time.Millisecond)
+
+		e := &cloudwatchlogs.OutputLogEvent{
+			Message:       aws.String(m),
+			Timestamp:     aws.Int64(now),
+			IngestionTime: aws.Int64(now),
+		}
+
+		svc.events[key] = append(svc.events[key], e)
+	}
+}
+
+func (svc *FakeLogsService) GetLogEvents(input *cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	key := fmt.Sprintf("%s#%s", aws.StringValue(input.LogGroupName), aws.StringValue(input.LogStreamName))
+
+	all, ok := svc.events[key]
+
+	if !ok {
+		msg := "The specified log stream does not exist."
+		return nil, awserr.New(cloudwatchlogs.ErrCodeResourceNotFoundException, msg, nil)
+	}
+
+	offset := 0
+
+	if input.NextToken != nil {
+
+		i, err := strconv.Atoi(strings.TrimPrefix(*input.NextToken, "f/"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		offset = i
+	}
+
+	if offset > len(all) {
+		offset = len(all)
+	}
+
+	last := offset + svc.PageSize
+
+	if last > len(all) {
+		last = len(all)
+	}
+
+	rsp := &cloudwatchlogs.GetLogEventsOutput{
+		Events:           all[offset:last],
+		NextForwardToken: aws.String(fmt.Sprintf("f/%d", last)),
+	}
+
+	return rsp, nil
+}
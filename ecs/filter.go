@@ -0,0 +1,346 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type S3Location struct {
+	Bucket string
+	Prefix string
+}
+
+func (l *S3Location) String() string {
+	return fmt.Sprintf("s3://%s/%s", l.Bucket, l.Prefix)
+}
+
+func (l *S3Location) Contains(bucket string, key string) bool {
+	return bucket == l.Bucket && strings.HasPrefix(key, l.Prefix)
+}
+
+// EventFilter decides which objects in S3 event notifications should be processed. Objects
+// in any of the derivatives caches are always skipped, since processing them would trigger
+// another event for every derivative written and so on. Keys are then checked against the
+// Exclude and Include glob patterns (see MatchKey). If Include is empty all keys that
+// are not excluded are processed.
+type EventFilter struct {
+	Derivatives []*S3Location
+	Include     []string
+	Exclude     []string
+}
+
+func NewEventFilter() *EventFilter {
+
+	f := EventFilter{
+		Derivatives: make([]*S3Location, 0),
+		Include:     make([]string, 0),
+		Exclude:     make([]string, 0),
+	}
+
+	return &f
+}
+
+type iiifCacheConfig struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Prefix string `json:"prefix"`
+}
+
+type iiifConfig struct {
+	Images struct {
+		Source *iiifCacheConfig `json:"source"`
+	} `json:"images"`
+	Derivatives struct {
+		Cache *iiifCacheConfig `json:"cache"`
+	} `json:"derivatives"`
+}
+
+// AddIIIFConfig reads the derivatives.cache block of a IIIF config, from a JSON-encoded
+// string or the path to a file containing one, and adds it to the list of locations to
+// skip. Caches that are not stored in S3 are ignored.
+func (f *EventFilter) AddIIIFConfig(str_config string) error {
+
+	body, err := readJSONDocument(str_config, "IIIF config")
+
+	if err != nil {
+		return err
+	}
+
+	return f.addIIIFConfig(body)
+}
+
+// AddConfigSource reads the IIIF config named name from source, a Go Cloud bucket URI as passed
+// to iiif-process with the -config-source flag, and adds its derivatives cache (see AddIIIFConfig).
+// Only "s3://" buckets, which are read using svc, and "file://" buckets are supported.
+func (f *EventFilter) AddConfigSource(ctx context.Context, svc S3Service, source string, name string) error {
+
+	err := ValidateBucketURI(source)
+
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(source)
+
+	if err != nil {
+		return err
+	}
+
+	if name == "" {
+		name = DEFAULT_CONFIG_NAME
+	}
+
+	var body []byte
+
+	switch u.Scheme {
+	case "file":
+
+		body, err = ioutil.ReadFile(filepath.Join(u.Path, name))
+
+	case "s3":
+
+		if svc == nil {
+			msg := fmt.Sprintf("Unable to read IIIF config from %s, no S3 client", source)
+			return errors.New(msg)
+		}
+
+		req := &s3.GetObjectInput{
+			Bucket: aws.String(u.Host),
+			Key:    aws.String(name),
+		}
+
+		var rsp *s3.GetObjectOutput
+
+		rsp, err = svc.GetObjectWithContext(ctx, req)
+
+		if err == nil {
+			defer rsp.Body.Close()
+			body, err = ioutil.ReadAll(rsp.Body)
+		}
+
+	default:
+		msg := fmt.Sprintf("Unable to read IIIF config from %s, unsupported bucket", source)
+		return errors.New(msg)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = checkJSONObject(body, "IIIF config")
+
+	if err != nil {
+		return err
+	}
+
+	return f.addIIIFConfig(body)
+}
+
+// AddTaskConfigs adds the derivatives caches of the IIIF configs that tasks are launched with,
+// which is to say opts.ConfigSource (see AddConfigSource) or opts.Config, if it can be read locally,
+// and any configs in opts.Sources. Configs that can't be read are logged and skipped since they
+// may only be available to tasks. It returns the number of configs that were added.
+func (f *EventFilter) AddTaskConfigs(ctx context.Context, opts *ProcessTaskOptions, svc S3Service) int {
+
+	configs := []*taskSources{
+		newTaskSources(opts),
+	}
+
+	if opts.Sources != nil {
+
+		for _, src := range opts.Sources.Sources {
+
+			if src.ConfigSource == "" && src.Config == "" {
+				continue
+			}
+
+			configs = append(configs, &taskSources{
+				Config:       src.Config,
+				ConfigSource: src.ConfigSource,
+				ConfigName:   src.ConfigName,
+			})
+		}
+	}
+
+	added := 0
+	seen := make(map[string]bool)
+
+	for _, cfg := range configs {
+
+		var err error
+
+		switch {
+		case cfg.ConfigSource != "":
+
+			k := cfg.ConfigSource + "#" + cfg.ConfigName
+
+			if seen[k] {
+				continue
+			}
+
+			seen[k] = true
+
+			err = f.AddConfigSource(ctx, svc, cfg.ConfigSource, cfg.ConfigName)
+
+			if err != nil {
+				log.Printf("[WARNING] Unable to read IIIF config %s from %s: %s\n", cfg.ConfigName, cfg.ConfigSource, err)
+				continue
+			}
+
+		case cfg.Config != "":
+
+			if seen[cfg.Config] {
+				continue
+			}
+
+			seen[cfg.Config] = true
+
+			// the config is usually only in the container
+
+			_, err = os.Stat(cfg.Config)
+
+			if err != nil {
+				continue
+			}
+
+			err = f.AddIIIFConfig(cfg.Config)
+
+			if err != nil {
+				log.Printf("[WARNING] Unable to read IIIF config %s: %s\n", cfg.Config, err)
+				continue
+			}
+
+		default:
+			continue
+		}
+
+		added += 1
+	}
+
+	return added
+}
+
+func (f *EventFilter) addIIIFConfig(body []byte) error {
+
+	var cfg iiifConfig
+
+	err := json.Unmarshal(body, &cfg)
+
+	if err != nil {
+		return err
+	}
+
+	cache := cfg.Derivatives.Cache
+
+	if cache == nil {
+		return errors.New("IIIF config is missing a derivatives.cache block")
+	}
+
+	derivatives := s3Location(cache)
+
+	if derivatives == nil {
+		return nil
+	}
+
+	source := s3Location(cfg.Images.Source)
+
+	if source != nil && derivatives.Contains(source.Bucket, source.Prefix) {
+		log.Printf("[WARNING] Derivatives cache %s contains the images source %s so events for source images will be skipped too\n", derivatives, source)
+	}
+
+	f.Derivatives = append(f.Derivatives, derivatives)
+	return nil
+}
+
+// Allow returns true if an object should be processed or false and the reason why not.
+func (f *EventFilter) Allow(bucket string, key string) (bool, string) {
+
+	for _, l := range f.Derivatives {
+
+		if l.Contains(bucket, key) {
+			return false, fmt.Sprintf("it is in the derivatives cache %s", l)
+		}
+	}
+
+	for _, pat := range f.Exclude {
+
+		if MatchKey(pat, key) {
+			return false, fmt.Sprintf("it matches the exclude pattern '%s'", pat)
+		}
+	}
+
+	if len(f.Include) == 0 {
+		return true, ""
+	}
+
+	for _, pat := range f.Include {
+
+		if MatchKey(pat, key) {
+			return true, ""
+		}
+	}
+
+	return false, "it does not match any include patterns"
+}
+
+// MatchKey reports whether key matches the shell pattern pat (see path.Match). Patterns
+// without a "/" are matched against the last element of key so "*.tif" matches both
+// "foo.tif" and "bar/foo.tif".
+func MatchKey(pat string, key string) bool {
+
+	if !strings.Contains(pat, "/") {
+		key = path.Base(key)
+	}
+
+	ok, err := path.Match(pat, key)
+
+	if err != nil {
+		return false
+	}
+
+	return ok
+}
+
+func s3Location(cfg *iiifCacheConfig) *S3Location {
+
+	if cfg == nil || strings.ToLower(cfg.Name) != "s3" || cfg.Path == "" {
+		return nil
+	}
+
+	// the path may contain the bucket name and then a prefix
+
+	parts := strings.SplitN(strings.Trim(cfg.Path, "/"), "/", 2)
+
+	bucket := parts[0]
+	prefix := ""
+
+	if len(parts) == 2 {
+		prefix = parts[1]
+	}
+
+	if cfg.Prefix != "" {
+		prefix = path.Join(prefix, cfg.Prefix)
+	}
+
+	if prefix != "" {
+		prefix = strings.TrimLeft(prefix, "/") + "/"
+	}
+
+	l := S3Location{
+		Bucket: bucket,
+		Prefix: prefix,
+	}
+
+	return &l
+}
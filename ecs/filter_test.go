@@ -0,0 +1,136 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestIIIFConfig returns a IIIF config whose derivatives are written to bucket under prefix.
+func newTestIIIFConfig(bucket string, prefix string) string {
+	return fmt.Sprintf(`{"images": {"source": {"name": "S3", "path": "%s", "prefix": "images"}}, "derivatives": {"cache": {"name": "S3", "path": "%s", "prefix": "%s"}}}`, bucket, bucket, prefix)
+}
+
+func TestEventFilterAllow(t *testing.T) {
+
+	f := NewEventFilter()
+	f.Exclude = []string{"*.json"}
+	f.Include = []string{"images/*"}
+
+	err := f.AddIIIFConfig(newTestIIIFConfig("example", "derivatives"))
+
+	if err != nil {
+		t.Fatalf("Failed to add config, %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		bucket string
+		key    string
+		allow  bool
+	}{
+		{"source", "example", "images/zuber.jpg", true},
+		{"derivative", "example", "derivatives/zuber.jpg/full/full/0/default.jpg", false},
+		{"other bucket", "other", "images/zuber.jpg", true},
+		{"excluded", "example", "images/process.json", false},
+		{"not included", "example", "uploads/zuber.jpg", false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			allow, reason := f.Allow(tt.bucket, tt.key)
+
+			if allow != tt.allow {
+				t.Fatalf("Expected %s/%s allowed to be %t, got %t (%s)", tt.bucket, tt.key, tt.allow, allow, reason)
+			}
+
+			if !allow && reason == "" {
+				t.Fatal("Expected a reason")
+			}
+		})
+	}
+}
+
+func TestEventFilterAddTaskConfigs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "filter")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	write := func(name string, body string) string {
+
+		path := filepath.Join(dir, name)
+
+		err := ioutil.WriteFile(path, []byte(body), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %s", path, err)
+		}
+
+		return path
+	}
+
+	local := write("local.json", newTestIIIFConfig("local", "derivatives"))
+	write(DEFAULT_CONFIG_NAME, newTestIIIFConfig("file", "derivatives"))
+	write("print.json", newTestIIIFConfig("print", "derivatives"))
+	write("invalid.json", `[]`)
+
+	svc := newFakeS3Service()
+	svc.Put("web.json", []byte(newTestIIIFConfig("web", "derivatives")))
+
+	tests := []struct {
+		name     string
+		opts     *ProcessTaskOptions
+		svc      S3Service
+		expected []string
+	}{
+		{"local config", &ProcessTaskOptions{Config: local}, svc, []string{"local"}},
+		{"container config", &ProcessTaskOptions{Config: "/etc/go-iiif/missing.json"}, svc, []string{}},
+		{"file config source", &ProcessTaskOptions{Config: local, ConfigSource: "file://" + dir}, svc, []string{"file"}},
+		{"s3 config source", &ProcessTaskOptions{ConfigSource: "s3://config", ConfigName: "web.json"}, svc, []string{"web"}},
+		{"s3 config source without client", &ProcessTaskOptions{ConfigSource: "s3://config", ConfigName: "web.json"}, nil, []string{}},
+		{"missing config source", &ProcessTaskOptions{ConfigSource: "s3://config", ConfigName: "missing.json"}, svc, []string{}},
+		{"invalid config source", &ProcessTaskOptions{ConfigSource: "file://" + dir, ConfigName: "invalid.json"}, svc, []string{}},
+		{"source map", &ProcessTaskOptions{
+			Config: "/etc/go-iiif/missing.json",
+			Sources: &SourceMap{
+				Sources: []*SourceMapping{
+					&SourceMapping{Bucket: "example", Prefix: "print/", ConfigSource: "file://" + dir, ConfigName: "print.json"},
+					&SourceMapping{Bucket: "example", Prefix: "web/", ConfigSource: "s3://config", ConfigName: "web.json"},
+					&SourceMapping{Bucket: "example", Prefix: "other/", ConfigSource: "s3://config", ConfigName: "web.json"},
+					&SourceMapping{Bucket: "example", Prefix: "default/"},
+				},
+			},
+		}, svc, []string{"print", "web"}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			f := NewEventFilter()
+
+			added := f.AddTaskConfigs(context.Background(), tt.opts, tt.svc)
+
+			if added != len(tt.expected) || len(f.Derivatives) != len(tt.expected) {
+				t.Fatalf("Expected %d derivatives caches, got %d (%d added)", len(tt.expected), len(f.Derivatives), added)
+			}
+
+			for i, bucket := range tt.expected {
+
+				if f.Derivatives[i].Bucket != bucket || f.Derivatives[i].Prefix != "derivatives/" {
+					t.Fatalf("Expected derivatives cache s3://%s/derivatives/, got %s", bucket, f.Derivatives[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Instruction is a single (labeled) derivative in a go-iiif processing instructions document.
+// Empty properties are assigned defaults by iiif-process.
+type Instruction struct {
+	Region   string `json:"region,omitempty"`
+	Size     string `json:"size,omitempty"`
+	Rotation string `json:"rotation,omitempty"`
+	Quality  string `json:"quality,omitempty"`
+	Format   string `json:"format,omitempty"`
+}
+
+// ValidateInstructions ensures that body is a JSON-encoded go-iiif processing instructions document,
+// which is to say one or more Instruction objects keyed by label. For example:
+//
+//	{"o": {"size": "full", "format": "", "rotation": "-1"}, "b": {"size": "!2048,1536", "format": "png"}}
+func ValidateInstructions(body []byte) error {
+
+	var instructions map[string]*Instruction
+
+	err := json.Unmarshal(body, &instructions)
+
+	if err != nil {
+		msg := fmt.Sprintf("Invalid instructions, %s", err)
+		return errors.New(msg)
+	}
+
+	if len(instructions) == 0 {
+		return errors.New("Invalid instructions, no labels")
+	}
+
+	for label, i := range instructions {
+
+		if strings.TrimSpace(label) == "" {
+			return errors.New("Invalid instructions, empty label")
+		}
+
+		if i == nil {
+			msg := fmt.Sprintf("Invalid instructions, label '%s' has no instructions", label)
+			return errors.New(msg)
+		}
+	}
+
+	return nil
+}
+
+// ReadInstructionsDocument returns a (validated) instructions document from a JSON-encoded
+// string or the path to a file containing one.
+func ReadInstructionsDocument(str_instructions string) ([]byte, error) {
+
+	body, err := readJSONDocument(str_instructions, "instructions")
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = ValidateInstructions(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return body, nil
+}
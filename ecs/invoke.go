@@ -0,0 +1,152 @@
+package ecs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_lambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/whosonfirst/go-whosonfirst-aws/lambda"
+)
+
+// LambdaFunctionError is returned when a Lambda function was invoked successfully but the
+// function itself failed. Log is the (decoded) tail of the function's log output, if it was
+// requested.
+type LambdaFunctionError struct {
+	Function string
+	Type     string
+	Message  string
+	Log      string
+}
+
+func (e *LambdaFunctionError) Error() string {
+
+	msg := fmt.Sprintf("Lambda function %s failed", e.Function)
+
+	if e.Type != "" {
+		msg = fmt.Sprintf("%s with %s", msg, e.Type)
+	}
+
+	if e.Message != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Message)
+	}
+
+	return msg
+}
+
+// lambdaErrorPayload is the payload returned by the Lambda runtime when a function fails.
+type lambdaErrorPayload struct {
+	Message string `json:"errorMessage"`
+	Type    string `json:"errorType"`
+}
+
+// InvokeLambdaHandlerFunc sends a JobRequest for the URIs in opts to a Lambda function running
+// in "lambda" mode. If lambda_type is "RequestResponse" the function's response is decoded and
+// returned, otherwise the response will be nil.
+func InvokeLambdaHandlerFunc(opts *ProcessTaskOptions, lambda_dsn string, lambda_func string, lambda_type string) (*ProcessTaskResponse, error) {
+
+	svc, err := lambda.NewLambdaServiceWithDSN(lambda_dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	job := NewJobRequest(opts)
+
+	enc_job, err := json.Marshal(job)
+
+	if err != nil {
+		return nil, err
+	}
+
+	input := &aws_lambda.InvokeInput{
+		FunctionName:   aws.String(lambda_func),
+		InvocationType: aws.String(lambda_type),
+		Payload:        enc_job,
+	}
+
+	if lambda_type == aws_lambda.InvocationTypeRequestResponse {
+		input.LogType = aws.String(aws_lambda.LogTypeTail)
+	}
+
+	rsp, err := svc.Invoke(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if lambda_type != aws_lambda.InvocationTypeRequestResponse {
+		return nil, nil
+	}
+
+	return DecodeInvokeOutput(lambda_func, rsp)
+}
+
+// DecodeInvokeOutput decodes the payload of a synchronous invocation of a Lambda function
+// running in "lambda" mode. If the function failed a *LambdaFunctionError is returned.
+func DecodeInvokeOutput(lambda_func string, rsp *aws_lambda.InvokeOutput) (*ProcessTaskResponse, error) {
+
+	var tail string
+
+	if rsp.LogResult != nil {
+
+		dec, err := base64.StdEncoding.DecodeString(*rsp.LogResult)
+
+		if err == nil {
+			tail = string(dec)
+		}
+	}
+
+	if rsp.FunctionError != nil {
+
+		fn_err := &LambdaFunctionError{
+			Function: lambda_func,
+			Type:     *rsp.FunctionError,
+			Log:      tail,
+		}
+
+		var payload lambdaErrorPayload
+
+		err := json.Unmarshal(rsp.Payload, &payload)
+
+		if err == nil {
+
+			fn_err.Message = payload.Message
+
+			if payload.Type != "" {
+				fn_err.Type = payload.Type
+			}
+
+		} else {
+			fn_err.Message = string(rsp.Payload)
+		}
+
+		return nil, fn_err
+	}
+
+	if aws.Int64Value(rsp.StatusCode) != 200 {
+
+		fn_err := &LambdaFunctionError{
+			Function: lambda_func,
+			Message:  fmt.Sprintf("Invocation returned status %d", aws.Int64Value(rsp.StatusCode)),
+			Log:      tail,
+		}
+
+		return nil, fn_err
+	}
+
+	var task_rsp *ProcessTaskResponse
+
+	err := json.Unmarshal(rsp.Payload, &task_rsp)
+
+	if err != nil {
+		msg := fmt.Sprintf("Failed to decode response from Lambda function %s: %s", lambda_func, err)
+		return nil, errors.New(msg)
+	}
+
+	// task_rsp will be nil if the function didn't launch a task (for example
+	// if all the URIs were filtered out)
+
+	return task_rsp, nil
+}
@@ -0,0 +1,182 @@
+package ecs
+
+import (
+	"encoding/base64"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_lambda "github.com/aws/aws-sdk-go/service/lambda"
+	"testing"
+)
+
+func TestDecodeInvokeOutput(t *testing.T) {
+
+	tail := base64.StdEncoding.EncodeToString([]byte("START RequestId: 1234\nEND RequestId: 1234\n"))
+
+	tests := []struct {
+		name           string
+		status         int64
+		function_error string
+		log_result     string
+		payload        string
+		task_id        string
+		fn_err         *LambdaFunctionError
+		ok             bool
+	}{
+		{
+			name:    "task",
+			status:  200,
+			payload: `{"JobId":"iiif-1234","TaskId":"arn:aws:ecs:us-east-1:000000000000:task/iiif/1234","URIs":["file:///zuber.jpg"],"Status":"PENDING"}`,
+			task_id: "arn:aws:ecs:us-east-1:000000000000:task/iiif/1234",
+			ok:      true,
+		},
+		{
+			name:    "no task",
+			status:  200,
+			payload: `null`,
+			ok:      true,
+		},
+		{
+			name:           "function error",
+			status:         200,
+			function_error: "Unhandled",
+			log_result:     tail,
+			payload:        `{"errorMessage":"Invalid callback URL 'ftp://example.com'","errorType":"errorString"}`,
+			fn_err: &LambdaFunctionError{
+				Function: "iiif-process",
+				Type:     "errorString",
+				Message:  "Invalid callback URL 'ftp://example.com'",
+				Log:      "START RequestId: 1234\nEND RequestId: 1234\n",
+			},
+		},
+		{
+			name:           "function error without type",
+			status:         200,
+			function_error: "Unhandled",
+			payload:        `{"errorMessage":"Task timed out after 3.00 seconds"}`,
+			fn_err: &LambdaFunctionError{
+				Function: "iiif-process",
+				Type:     "Unhandled",
+				Message:  "Task timed out after 3.00 seconds",
+			},
+		},
+		{
+			name:           "function error not json",
+			status:         200,
+			function_error: "Unhandled",
+			log_result:     "not base64!",
+			payload:        `Runtime exited with error: signal: killed`,
+			fn_err: &LambdaFunctionError{
+				Function: "iiif-process",
+				Type:     "Unhandled",
+				Message:  "Runtime exited with error: signal: killed",
+			},
+		},
+		{
+			name:    "status",
+			status:  500,
+			payload: `{"JobId":"iiif-1234"}`,
+			fn_err: &LambdaFunctionError{
+				Function: "iiif-process",
+				Message:  "Invocation returned status 500",
+			},
+		},
+		{
+			name:    "invalid payload",
+			status:  200,
+			payload: `{"JobId":`,
+		},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			rsp := &aws_lambda.InvokeOutput{
+				StatusCode: aws.Int64(tt.status),
+				Payload:    []byte(tt.payload),
+			}
+
+			if tt.function_error != "" {
+				rsp.FunctionError = aws.String(tt.function_error)
+			}
+
+			if tt.log_result != "" {
+				rsp.LogResult = aws.String(tt.log_result)
+			}
+
+			task_rsp, err := DecodeInvokeOutput("iiif-process", rsp)
+
+			if tt.ok {
+
+				if err != nil {
+					t.Fatalf("Failed to decode output, %s", err)
+				}
+
+				if tt.task_id == "" {
+
+					if task_rsp != nil {
+						t.Fatalf("Expected no task, got %v", task_rsp)
+					}
+
+					return
+				}
+
+				if task_rsp == nil || task_rsp.TaskId != tt.task_id {
+					t.Fatalf("Expected task %s, got %v", tt.task_id, task_rsp)
+				}
+
+				if task_rsp.JobId != "iiif-1234" || len(task_rsp.URIs) != 1 || task_rsp.URIs[0].String() != "file:///zuber.jpg" {
+					t.Fatalf("Unexpected task %v", task_rsp)
+				}
+
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("Expected decoding output to fail, got %v", task_rsp)
+			}
+
+			if task_rsp != nil {
+				t.Fatalf("Expected no task, got %v", task_rsp)
+			}
+
+			fn_err, ok := err.(*LambdaFunctionError)
+
+			if tt.fn_err == nil {
+
+				if ok {
+					t.Fatalf("Expected a decoding error, got a *LambdaFunctionError %v", err)
+				}
+
+				return
+			}
+
+			if !ok {
+				t.Fatalf("Expected a *LambdaFunctionError, got %v", err)
+			}
+
+			if *fn_err != *tt.fn_err {
+				t.Fatalf("Expected %#v, got %#v", tt.fn_err, fn_err)
+			}
+		})
+	}
+}
+
+func TestLambdaFunctionErrorError(t *testing.T) {
+
+	tests := []struct {
+		err      *LambdaFunctionError
+		expected string
+	}{
+		{&LambdaFunctionError{Function: "iiif-process"}, "Lambda function iiif-process failed"},
+		{&LambdaFunctionError{Function: "iiif-process", Type: "Unhandled"}, "Lambda function iiif-process failed with Unhandled"},
+		{&LambdaFunctionError{Function: "iiif-process", Message: "boom"}, "Lambda function iiif-process failed: boom"},
+		{&LambdaFunctionError{Function: "iiif-process", Type: "errorString", Message: "boom", Log: "ignored"}, "Lambda function iiif-process failed with errorString: boom"},
+	}
+
+	for _, tt := range tests {
+
+		if tt.err.Error() != tt.expected {
+			t.Fatalf("Expected '%s', got '%s'", tt.expected, tt.err.Error())
+		}
+	}
+}
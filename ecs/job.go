@@ -0,0 +1,446 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-iiif/go-iiif-uri"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// JOB_ID_PREFIX is the prefix for job IDs generated by NewJobId, which is used to find the
+// tasks launched by this package.
+const JOB_ID_PREFIX string = "iiif-"
+
+// JOB_ID_ENV_VAR is the environment variable used to pass a task's job ID to its container.
+const JOB_ID_ENV_VAR string = "IIIF_PROCESS_JOB_ID"
+
+// Job IDs are recorded as tags so they are limited to the characters (and length) that tag
+// values can contain. IDs that also match re_started_by are recorded as a task's startedBy
+// property.
+
+var re_job_id = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]{1,256}$`)
+
+var re_started_by = regexp.MustCompile(`^[a-zA-Z0-9_\-]{1,36}$`)
+
+// JobRequest is the native payload for asking a Lambda function (running in "lambda" mode)
+// to launch a processing task. Unlike S3 events it preserves URIs exactly as they were
+// written (including any idsecret or rewrite query parameters) and allows some settings
+// to be overridden on a per-job basis. Empty or nil properties use the Lambda function's
+// defaults.
+type JobRequest struct {
+	JobId        string   `json:"job_id,omitempty"`
+	URIs         []string `json:"uris"`
+	Instructions string   `json:"instructions,omitempty"`
+	// A Go Cloud bucket URI containing the instructions named InstructionsName. It takes
+	// precedence over Instructions.
+	InstructionsSource string `json:"instructions_source,omitempty"`
+	InstructionsName   string `json:"instructions_name,omitempty"`
+	// An inline instructions document which is staged to the Lambda function's staging bucket
+	// when the task is launched. It takes precedence over InstructionsSource and Instructions.
+	InstructionsDocument json.RawMessage `json:"instructions_document,omitempty"`
+	Report               *bool           `json:"report,omitempty"`
+	ReportName           string          `json:"report_name,omitempty"`
+	// A URL that the outcome of the job (a JobResult) will be POSTed to once the task has
+	// been launched or, if the Lambda function is configured to wait, once it has completed.
+	Callback string `json:"callback,omitempty"`
+}
+
+type JobResult struct {
+	JobId string               `json:"job_id,omitempty"`
+	Task  *ProcessTaskResponse `json:"task,omitempty"`
+	Error string               `json:"error,omitempty"`
+}
+
+// Redacted returns a copy of r whose task has had its secrets redacted (see ProcessTaskResponse.Redacted).
+func (r *JobResult) Redacted() *JobResult {
+
+	redacted := *r
+
+	if r.Task != nil {
+		redacted.Task = r.Task.Redacted()
+	}
+
+	return &redacted
+}
+
+// NewJobId returns a new, random, job ID starting with JOB_ID_PREFIX. Generated IDs are
+// short enough to be used as a task's startedBy property.
+func NewJobId() (string, error) {
+
+	b := make([]byte, 15)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	id := fmt.Sprintf("%s%x", JOB_ID_PREFIX, b)
+	return id, nil
+}
+
+func ValidateJobId(id string) error {
+
+	if !re_job_id.MatchString(id) {
+		msg := fmt.Sprintf("Invalid job ID '%s'", id)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// NewJobRequest returns a JobRequest for the URIs and (non-default) settings in opts.
+func NewJobRequest(opts *ProcessTaskOptions) *JobRequest {
+
+	str_uris := make([]string, len(opts.URIs))
+
+	for i, u := range opts.URIs {
+		str_uris[i] = u.String()
+	}
+
+	job := JobRequest{
+		JobId:                opts.JobId,
+		URIs:                 str_uris,
+		Instructions:         opts.Instructions,
+		InstructionsSource:   opts.InstructionsSource,
+		InstructionsName:     opts.InstructionsName,
+		InstructionsDocument: opts.InstructionsDocument,
+		Callback:             opts.Callback,
+	}
+
+	if opts.Report {
+		report := true
+		job.Report = &report
+		job.ReportName = opts.ReportName
+	}
+
+	return &job
+}
+
+// IsJobRequest returns true if payload looks like a JSON-encoded JobRequest rather than, say,
+// an S3 event.
+func IsJobRequest(payload []byte) bool {
+
+	var probe map[string]json.RawMessage
+
+	err := json.Unmarshal(payload, &probe)
+
+	if err != nil {
+		return false
+	}
+
+	_, ok := probe["uris"]
+	return ok
+}
+
+// ProcessTaskOptions returns a copy of defaults updated with the URIs and settings in job.
+func (job *JobRequest) ProcessTaskOptions(defaults *ProcessTaskOptions) (*ProcessTaskOptions, error) {
+
+	if len(job.URIs) == 0 {
+		return nil, errors.New("Job has no URIs")
+	}
+
+	// URIs are passed to iiif-process as written (see ParseURI) since the go-iiif-uri drivers
+	// don't round-trip them: idsecret URIs lose their format and label and are assigned random
+	// secrets if they don't have any and file URIs lose their escaping
+
+	uris := make([]uri.URI, len(job.URIs))
+
+	for i, str_uri := range job.URIs {
+
+		u, err := ParseURI(str_uri)
+
+		if err != nil {
+			msg := fmt.Sprintf("Invalid URI '%s': %s", str_uri, err)
+			return nil, errors.New(msg)
+		}
+
+		uris[i] = u
+	}
+
+	if job.JobId != "" {
+
+		err := ValidateJobId(job.JobId)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if job.InstructionsSource != "" {
+
+		err := ValidateBucketURI(job.InstructionsSource)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(job.InstructionsDocument) > 0 {
+
+		if defaults.Staging == nil {
+			return nil, errors.New("Instructions documents require a staging bucket")
+		}
+
+		err := ValidateInstructions(job.InstructionsDocument)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if job.Callback != "" {
+
+		u, err := url.Parse(job.Callback)
+
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			msg := fmt.Sprintf("Invalid callback URL '%s'", job.Callback)
+			return nil, errors.New(msg)
+		}
+
+		if !allowCallbackHost(u, defaults.CallbackHosts) {
+			msg := fmt.Sprintf("Callback host '%s' is not allowed", u.Host)
+			return nil, errors.New(msg)
+		}
+	}
+
+	opts := *defaults
+	opts.URIs = uris
+
+	if job.JobId != "" {
+		opts.JobId = job.JobId
+	}
+
+	sources := newTaskSources(&opts)
+
+	if job.Instructions != "" {
+		sources.setInstructions(job.Instructions)
+	}
+
+	if job.InstructionsSource != "" {
+		sources.setInstructionsSource(job.InstructionsSource, job.InstructionsName)
+	}
+
+	if len(job.InstructionsDocument) > 0 {
+		sources.InstructionsDocument = job.InstructionsDocument
+	}
+
+	sources.apply(&opts)
+
+	if job.Report != nil {
+		opts.Report = *job.Report
+	}
+
+	if job.ReportName != "" {
+		opts.ReportName = job.ReportName
+	}
+
+	if job.Callback != "" {
+		opts.Callback = job.Callback
+	}
+
+	return &opts, nil
+}
+
+// allowCallbackHost returns true if hosts is empty or u's host, with or without its port,
+// is one of hosts.
+func allowCallbackHost(u *url.URL, hosts []string) bool {
+
+	if len(hosts) == 0 {
+		return true
+	}
+
+	for _, h := range hosts {
+
+		if strings.EqualFold(h, u.Host) || strings.EqualFold(h, u.Hostname()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func launchJob(ctx context.Context, defaults *ProcessTaskOptions, job *JobRequest) (*ProcessTaskResponse, error) {
+
+	opts, err := job.ProcessTaskOptions(defaults)
+
+	if err != nil {
+		return nil, err
+	}
+
+	l, err := NewProcessTaskLauncherWithDSN(opts.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.launchJob(ctx, opts)
+}
+
+// launchJob launches a task for opts and, if opts.Callback is set, posts the outcome to it. As
+// with LaunchProcessTask the response is returned alongside any error waiting for the task.
+// The job ID is assigned here, rather than by LaunchProcessTask, so that it can be included in
+// the outcome.
+func (l *ProcessTaskLauncher) launchJob(ctx context.Context, opts *ProcessTaskOptions) (*ProcessTaskResponse, error) {
+
+	opts, err := withJobId(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// jobs are launched as a single task so reject any whose URIs won't fit in one before
+	// launching it, rather than when ECS fails to, but still post the outcome
+
+	err = ValidateCommandSize(opts)
+
+	var rsp *ProcessTaskResponse
+
+	if err == nil {
+		rsp, err = l.LaunchProcessTask(ctx, opts)
+	}
+
+	if opts.Callback != "" {
+
+		result := &JobResult{
+			JobId: opts.JobId,
+			Task:  rsp,
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		cb_err := PostJobResult(ctx, opts.Callback, result)
+
+		if cb_err != nil {
+			log.Printf("[WARNING] Failed to post result for job '%s' to %s: %s\n", opts.JobId, opts.Callback, cb_err)
+		}
+	}
+
+	if err != nil {
+		return rsp, err
+	}
+
+	enc_rsp, err := json.Marshal(rsp.Redacted())
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println(string(enc_rsp))
+
+	return rsp, nil
+}
+
+// postBatchJobResults posts a JobResult to opts.Callback, if it is set, for each task in rsp
+// along with one for any URIs that failed to launch. Errors are logged rather than returned.
+func postBatchJobResults(ctx context.Context, opts *ProcessTaskOptions, rsp *BatchProcessTaskResponse, err error) {
+
+	if opts.Callback == "" {
+		return
+	}
+
+	results := make([]*JobResult, 0)
+	not_launched := rsp == nil
+
+	if rsp != nil {
+
+		for _, t := range rsp.Tasks {
+
+			result := &JobResult{
+				JobId: t.JobId,
+				Task:  t,
+			}
+
+			// all the URIs in a task share the same error
+
+			if len(t.URIs) > 0 {
+				result.Error = rsp.Errors[t.URIs[0].String()]
+			}
+
+			results = append(results, result)
+		}
+
+		for str_uri := range rsp.Errors {
+
+			_, launched := rsp.TaskIds[str_uri]
+
+			if !launched {
+				not_launched = true
+			}
+		}
+	}
+
+	if err != nil && not_launched {
+
+		result := &JobResult{
+			JobId: opts.JobId,
+			Error: err.Error(),
+		}
+
+		results = append(results, result)
+	}
+
+	for _, result := range results {
+
+		cb_err := PostJobResult(ctx, opts.Callback, result)
+
+		if cb_err != nil {
+			log.Printf("[WARNING] Failed to post result for job '%s' to %s: %s\n", result.JobId, opts.Callback, cb_err)
+		}
+	}
+}
+
+// callback_client doesn't follow redirects so that callbacks can't be used to reach hosts
+// that aren't allowed by ProcessTaskOptions.CallbackHosts.
+var callback_client = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func PostJobResult(ctx context.Context, callback string, result *JobResult) error {
+
+	enc, err := json.Marshal(result)
+
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequest("POST", callback, bytes.NewReader(enc))
+
+	if err != nil {
+		return err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	rsp, err := callback_client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		msg := fmt.Sprintf("Callback returned status %s", rsp.Status)
+		return errors.New(msg)
+	}
+
+	return nil
+}
@@ -0,0 +1,260 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/go-iiif/go-iiif-uri"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLaunchJobCallbackJobId(t *testing.T) {
+
+	results := make(chan *JobResult, 1)
+
+	callback := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		var result *JobResult
+
+		err := json.NewDecoder(req.Body).Decode(&result)
+
+		if err != nil {
+			t.Errorf("Failed to decode job result, %s", err)
+		}
+
+		results <- result
+	}))
+
+	defer callback.Close()
+
+	u, err := uri.NewURI("file:///zuber.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to parse URI, %s", err)
+	}
+
+	opts := &ProcessTaskOptions{
+		Cluster:      "iiif",
+		Task:         "iiif-process:1",
+		Container:    "iiif-process",
+		Config:       "/etc/go-iiif/config.json",
+		Instructions: "/etc/go-iiif/instructions.json",
+		URIs:         []uri.URI{u},
+		Callback:     callback.URL,
+	}
+
+	l := NewProcessTaskLauncher(NewFakeECSService(), nil)
+
+	rsp, err := l.launchJob(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch job, %s", err)
+	}
+
+	result := <-results
+
+	if !strings.HasPrefix(result.JobId, JOB_ID_PREFIX) {
+		t.Fatalf("Expected callback to have a generated job ID, got '%s'", result.JobId)
+	}
+
+	if result.Task == nil || result.Task.JobId != result.JobId {
+		t.Fatalf("Expected callback task to have job ID '%s', got %v", result.JobId, result.Task)
+	}
+
+	if rsp.JobId != result.JobId {
+		t.Fatalf("Expected response to have job ID '%s', got '%s'", result.JobId, rsp.JobId)
+	}
+}
+
+func TestJobRequestURIs(t *testing.T) {
+
+	tests := []struct {
+		name string
+		uri  string
+	}{
+		{"file", "file:///zuber.jpg"},
+		{"file with target", "file:///zuber.jpg?target=zuber"},
+		{"file percent-encoded", "file:///my%20photo%281%29.jpg"},
+		{"file percent-encoded query", "file:///zuber%3Fv=1.jpg"},
+		{"rewrite", "rewrite:///zuber.jpg?target=avocado/toast"},
+		{"idsecret", "idsecret:///zuber.jpg?id=1234"},
+		{"idsecret with secrets", "idsecret:///zuber.jpg?id=1234&secret=s33kret&secret_o=0r1g1nal"},
+		{"idsecret with format and label", "idsecret:///zuber.png?id=1234&secret=s33kret&secret_o=0r1g1nal&format=jpg&label=o"},
+	}
+
+	defaults := &ProcessTaskOptions{
+		Cluster:      "iiif",
+		Task:         "iiif-process:1",
+		Container:    "iiif-process",
+		Config:       "/etc/go-iiif/config.json",
+		Instructions: "/etc/go-iiif/instructions.json",
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			job := &JobRequest{
+				URIs: []string{tt.uri},
+			}
+
+			opts, err := job.ProcessTaskOptions(defaults)
+
+			if err != nil {
+				t.Fatalf("Failed to derive options for %s, %s", tt.uri, err)
+			}
+
+			if opts.URIs[0].String() != tt.uri {
+				t.Fatalf("Expected URI %s, got %s", tt.uri, opts.URIs[0].String())
+			}
+
+			cmd, err := ProcessCommand(opts)
+
+			if err != nil {
+				t.Fatalf("Failed to build command for %s, %s", tt.uri, err)
+			}
+
+			if aws.StringValue(cmd[len(cmd)-1]) != tt.uri {
+				t.Fatalf("Expected command to end with %s, got %s", tt.uri, aws.StringValue(cmd[len(cmd)-1]))
+			}
+		})
+	}
+}
+
+func TestLaunchJobTooLarge(t *testing.T) {
+
+	results := make(chan *JobResult, 1)
+
+	callback := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		var result *JobResult
+
+		err := json.NewDecoder(req.Body).Decode(&result)
+
+		if err != nil {
+			t.Errorf("Failed to decode job result, %s", err)
+		}
+
+		results <- result
+	}))
+
+	defer callback.Close()
+
+	str_uris := make([]string, 300)
+
+	for i := range str_uris {
+		str_uris[i] = fmt.Sprintf("file:///images/image-%04d.jpg", i)
+	}
+
+	opts := newTestProcessTaskOptions(t, str_uris...)
+	opts.Callback = callback.URL
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	_, err := l.launchJob(context.Background(), opts)
+
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum size") {
+		t.Fatalf("Expected job to be too large, got %v", err)
+	}
+
+	if len(svc.RunTaskInputs) != 0 {
+		t.Fatalf("Expected no tasks to be launched, got %d", len(svc.RunTaskInputs))
+	}
+
+	// the outcome is still posted so that jobs from asynchronous invocations don't just vanish
+
+	result := <-results
+
+	if result.Task != nil || result.Error != err.Error() {
+		t.Fatalf("Expected callback to have error '%s', got %v", err, result)
+	}
+}
+
+func TestJobRequestCallbackHosts(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		hosts    []string
+		callback string
+		ok       bool
+	}{
+		{"no hosts", nil, "http://169.254.169.254/latest/meta-data", true},
+		{"host", []string{"example.com"}, "https://example.com/iiif/callback", true},
+		{"host with port", []string{"example.com"}, "https://example.com:8443/iiif/callback", true},
+		{"host case", []string{"Example.com"}, "https://example.COM/iiif/callback", true},
+		{"host and port", []string{"example.com:8443"}, "https://example.com:8443/iiif/callback", true},
+		{"host and other port", []string{"example.com:8443"}, "https://example.com/iiif/callback", false},
+		{"other host", []string{"example.com"}, "http://169.254.169.254/latest/meta-data", false},
+		{"subdomain", []string{"example.com"}, "https://www.example.com/iiif/callback", false},
+		{"userinfo", []string{"example.com"}, "https://example.com@169.254.169.254/iiif/callback", false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			defaults := newTestProcessTaskOptions(t)
+			defaults.CallbackHosts = tt.hosts
+
+			job := &JobRequest{
+				URIs:     []string{"file:///zuber.jpg"},
+				Callback: tt.callback,
+			}
+
+			opts, err := job.ProcessTaskOptions(defaults)
+
+			if !tt.ok {
+
+				if err == nil || !strings.Contains(err.Error(), "is not allowed") {
+					t.Fatalf("Expected callback %s to be rejected, got %v", tt.callback, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected callback %s to be allowed, %s", tt.callback, err)
+			}
+
+			if opts.Callback != tt.callback {
+				t.Fatalf("Expected callback %s, got %s", tt.callback, opts.Callback)
+			}
+		})
+	}
+}
+
+func TestPostJobResultRedirect(t *testing.T) {
+
+	redirected := false
+
+	other := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		redirected = true
+	}))
+
+	defer other.Close()
+
+	callback := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+		http.Redirect(rsp, req, other.URL, http.StatusTemporaryRedirect)
+	}))
+
+	defer callback.Close()
+
+	result := &JobResult{
+		JobId: "test-job",
+	}
+
+	err := PostJobResult(context.Background(), callback.URL, result)
+
+	if err == nil || !strings.Contains(err.Error(), "307") {
+		t.Fatalf("Expected callback to fail with a redirect, got %v", err)
+	}
+
+	if redirected {
+		t.Fatal("Expected callback not to follow redirect")
+	}
+}
@@ -0,0 +1,252 @@
+package ecs
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"strings"
+	"time"
+)
+
+// MAX_DESCRIBE_TASKS is the largest number of tasks that can be described at once.
+const MAX_DESCRIBE_TASKS int = 100
+
+// TaskDescription is a summary of a task launched by LaunchProcessTask.
+type TaskDescription struct {
+	Task            *ProcessTaskResponse
+	Group           string `json:",omitempty"`
+	DesiredStatus   string `json:",omitempty"`
+	StopCode        string `json:",omitempty"`
+	PendingDuration string `json:",omitempty"`
+	RunDuration     string `json:",omitempty"`
+}
+
+func NewTaskDescription(task *aws_ecs.Task, container string) *TaskDescription {
+
+	d := &TaskDescription{
+		Task:          NewProcessTaskResponse(task, container),
+		Group:         aws.StringValue(task.Group),
+		DesiredStatus: aws.StringValue(task.DesiredStatus),
+		StopCode:      aws.StringValue(task.StopCode),
+	}
+
+	if task.CreatedAt != nil && task.StartedAt != nil {
+		d.PendingDuration = task.StartedAt.Sub(*task.CreatedAt).Round(time.Second).String()
+	}
+
+	if task.StartedAt != nil {
+
+		stopped := time.Now()
+
+		if task.StoppedAt != nil {
+			stopped = *task.StoppedAt
+		}
+
+		d.RunDuration = stopped.Sub(*task.StartedAt).Round(time.Second).String()
+	}
+
+	return d
+}
+
+// IsProcessTask returns true if task was launched by LaunchProcessTask, meaning that it has a
+// JOB_ID_TAG tag or a startedBy property that starts with JOB_ID_PREFIX.
+func IsProcessTask(task *aws_ecs.Task) bool {
+
+	for _, t := range task.Tags {
+
+		if aws.StringValue(t.Key) == JOB_ID_TAG {
+			return true
+		}
+	}
+
+	return strings.HasPrefix(aws.StringValue(task.StartedBy), JOB_ID_PREFIX)
+}
+
+// DescribeProcessTask returns a TaskDescription for task_id (a task ID or ARN) in opts.Cluster.
+func (l *ProcessTaskLauncher) DescribeProcessTask(ctx context.Context, opts *ProcessTaskOptions, task_id string) (*TaskDescription, error) {
+
+	task, err := DescribeTask(l.service, opts.Cluster, task_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewTaskDescription(task, opts.Container), nil
+}
+
+// taskDefinitionFamily returns the family of task, which may be a family, a family:revision
+// pair or a task definition ARN.
+func taskDefinitionFamily(task string) string {
+
+	task = task[strings.LastIndex(task, "/")+1:]
+
+	if idx := strings.Index(task, ":"); idx != -1 {
+		task = task[:idx]
+	}
+
+	return task
+}
+
+// ListProcessTasks returns the running and recently stopped (ECS forgets about tasks about an
+// hour after they stop) tasks in opts.Cluster that were launched by LaunchProcessTask. If
+// job_id is not empty only the tasks for that job are returned. Tasks are listed by their
+// startedBy property, for job IDs that can be one, or otherwise by the family of opts.Task
+// so that only those tasks need to be described.
+func (l *ProcessTaskLauncher) ListProcessTasks(ctx context.Context, opts *ProcessTaskOptions, job_id string) ([]*TaskDescription, error) {
+
+	arns := make([]*string, 0)
+
+	for _, status := range []string{aws_ecs.DesiredStatusRunning, aws_ecs.DesiredStatusStopped} {
+
+		input := &aws_ecs.ListTasksInput{
+			Cluster:       aws.String(opts.Cluster),
+			DesiredStatus: aws.String(status),
+		}
+
+		// if the job ID can be a startedBy property then let ECS do the filtering
+
+		// otherwise only list tasks from the same task definition family since ECS
+		// can't filter on tags or a startedBy prefix
+
+		if job_id != "" && re_started_by.MatchString(job_id) {
+			input.StartedBy = aws.String(job_id)
+		} else if opts.Task != "" {
+			input.Family = aws.String(taskDefinitionFamily(opts.Task))
+		}
+
+		for {
+
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			rsp, err := l.service.ListTasks(input)
+
+			if err != nil {
+				return nil, err
+			}
+
+			arns = append(arns, rsp.TaskArns...)
+
+			if rsp.NextToken == nil {
+				break
+			}
+
+			input.NextToken = rsp.NextToken
+		}
+	}
+
+	descriptions := make([]*TaskDescription, 0)
+
+	for len(arns) > 0 {
+
+		n := MAX_DESCRIBE_TASKS
+
+		if len(arns) < n {
+			n = len(arns)
+		}
+
+		input := &aws_ecs.DescribeTasksInput{
+			Cluster: aws.String(opts.Cluster),
+			Tasks:   arns[:n],
+			Include: []*string{
+				aws.String(aws_ecs.TaskFieldTags),
+			},
+		}
+
+		arns = arns[n:]
+
+		rsp, err := l.service.DescribeTasks(input)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range rsp.Tasks {
+
+			if !IsProcessTask(task) {
+				continue
+			}
+
+			if job_id != "" && TaskJobId(task) != job_id {
+				continue
+			}
+
+			descriptions = append(descriptions, NewTaskDescription(task, opts.Container))
+		}
+	}
+
+	return descriptions, nil
+}
+
+// StopProcessTasks stops id, which may be a task ID or ARN or a job ID in which case all the
+// running tasks for that job are stopped. It returns a TaskDescription for each task that was
+// stopped.
+func (l *ProcessTaskLauncher) StopProcessTasks(ctx context.Context, opts *ProcessTaskOptions, id string, reason string) ([]*TaskDescription, error) {
+
+	task_ids := make([]string, 0)
+
+	if strings.HasPrefix(id, "arn:aws:ecs:") {
+		task_ids = append(task_ids, id)
+	} else {
+
+		tasks, err := l.ListProcessTasks(ctx, opts, id)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range tasks {
+
+			if t.DesiredStatus == TASK_STATUS_STOPPED {
+				continue
+			}
+
+			task_ids = append(task_ids, t.Task.TaskId)
+		}
+
+		// not a job ID so it must be a task ID
+
+		if len(tasks) == 0 {
+			task_ids = append(task_ids, id)
+		}
+	}
+
+	stopped := make([]*TaskDescription, 0)
+
+	for _, task_id := range task_ids {
+
+		// make sure the task exists (and is one of ours) first so that we can
+		// return a meaningful error
+
+		task, err := DescribeTask(l.service, opts.Cluster, task_id)
+
+		if err != nil {
+			return stopped, err
+		}
+
+		if !IsProcessTask(task) {
+			return stopped, &NotProcessTaskError{TaskId: task_id}
+		}
+
+		input := &aws_ecs.StopTaskInput{
+			Cluster: aws.String(opts.Cluster),
+			Task:    aws.String(task_id),
+			Reason:  aws.String(reason),
+		}
+
+		rsp, err := l.service.StopTask(input)
+
+		if err != nil {
+			return stopped, err
+		}
+
+		// StopTask doesn't return tags
+
+		rsp.Task.Tags = task.Tags
+
+		stopped = append(stopped, NewTaskDescription(rsp.Task, opts.Container))
+	}
+
+	return stopped, nil
+}
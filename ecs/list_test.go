@@ -0,0 +1,217 @@
+package ecs
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"sort"
+	"testing"
+)
+
+func launchTestJobTask(t *testing.T, l *ProcessTaskLauncher, job_id string) string {
+
+	opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+	opts.JobId = job_id
+
+	rsp, err := l.LaunchProcessTask(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task for %s, %s", job_id, err)
+	}
+
+	return rsp.TaskId
+}
+
+// launchTestOtherTask launches a task that wasn't launched by LaunchProcessTask.
+func launchTestOtherTask(t *testing.T, svc *FakeECSService, task string) string {
+
+	input := &aws_ecs.RunTaskInput{
+		Cluster:        aws.String("iiif"),
+		TaskDefinition: aws.String(task),
+	}
+
+	rsp, err := svc.RunTask(input)
+
+	if err != nil {
+		t.Fatalf("Failed to launch %s task, %s", task, err)
+	}
+
+	return aws.StringValue(rsp.Tasks[0].TaskArn)
+}
+
+func TestTaskDefinitionFamily(t *testing.T) {
+
+	tests := []struct {
+		task     string
+		expected string
+	}{
+		{"iiif-process", "iiif-process"},
+		{"iiif-process:1", "iiif-process"},
+		{"arn:aws:ecs:us-east-1:000000000000:task-definition/iiif-process:12", "iiif-process"},
+	}
+
+	for _, tt := range tests {
+
+		family := taskDefinitionFamily(tt.task)
+
+		if family != tt.expected {
+			t.Fatalf("Expected family of %s to be '%s', got '%s'", tt.task, tt.expected, family)
+		}
+	}
+}
+
+func TestListProcessTasks(t *testing.T) {
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	a1 := launchTestJobTask(t, l, "iiif-a")
+	a2 := launchTestJobTask(t, l, "iiif-a")
+	b := launchTestJobTask(t, l, "iiif-b")
+
+	// job IDs that can't be a startedBy property are only recorded as a tag
+
+	c := launchTestJobTask(t, l, "job c")
+
+	// a task from the same task definition family that wasn't launched by
+	// LaunchProcessTask and one from another family
+
+	launchTestOtherTask(t, svc, "iiif-process:2")
+	other := launchTestOtherTask(t, svc, "other:1")
+
+	tests := []struct {
+		job_id   string
+		expected []string
+	}{
+		{"", []string{a1, a2, b, c}},
+		{"iiif-a", []string{a1, a2}},
+		{"iiif-b", []string{b}},
+		{"job c", []string{c}},
+		{"iiif-d", []string{}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.job_id, func(t *testing.T) {
+
+			opts := newTestProcessTaskOptions(t)
+
+			tasks, err := l.ListProcessTasks(context.Background(), opts, tt.job_id)
+
+			if err != nil {
+				t.Fatalf("Failed to list tasks, %s", err)
+			}
+
+			task_ids := make([]string, len(tasks))
+
+			for i, d := range tasks {
+				task_ids[i] = d.Task.TaskId
+			}
+
+			sort.Strings(task_ids)
+
+			if len(task_ids) != len(tt.expected) {
+				t.Fatalf("Expected tasks %v, got %v", tt.expected, task_ids)
+			}
+
+			for i, task_id := range tt.expected {
+
+				if task_ids[i] != task_id {
+					t.Fatalf("Expected tasks %v, got %v", tt.expected, task_ids)
+				}
+			}
+		})
+	}
+
+	// tasks from other task definition families should never have been described
+	// (which would have advanced them from PENDING)
+
+	_, task, _ := svc.task(other)
+
+	if aws.StringValue(task.LastStatus) != TASK_STATUS_PENDING {
+		t.Fatalf("Expected task %s not to have been described", other)
+	}
+}
+
+func TestStopProcessTasks(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		id      func(a1 string, a2 string, other string) string
+		stopped int
+		ok      bool
+	}{
+		{"job", func(a1 string, a2 string, other string) string { return "iiif-a" }, 2, true},
+		{"arn", func(a1 string, a2 string, other string) string { return a1 }, 1, true},
+		{"task id", func(a1 string, a2 string, other string) string { return TaskIdFromArn(a2) }, 1, true},
+		{"other", func(a1 string, a2 string, other string) string { return other }, 0, false},
+		{"unknown", func(a1 string, a2 string, other string) string { return "iiif-d" }, 0, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			svc := NewFakeECSService()
+			l := NewProcessTaskLauncher(svc, nil)
+
+			a1 := launchTestJobTask(t, l, "iiif-a")
+			a2 := launchTestJobTask(t, l, "iiif-a")
+			b := launchTestJobTask(t, l, "iiif-b")
+			other := launchTestOtherTask(t, svc, "other:1")
+
+			opts := newTestProcessTaskOptions(t)
+
+			stopped, err := l.StopProcessTasks(context.Background(), opts, tt.id(a1, a2, other), "Stopped by test")
+
+			if !tt.ok {
+
+				if err == nil {
+					t.Fatalf("Expected stopping %s to fail", tt.name)
+				}
+
+				if tt.name == "other" {
+
+					if _, ok := err.(*NotProcessTaskError); !ok {
+						t.Fatalf("Expected a *NotProcessTaskError, got %v", err)
+					}
+
+					_, task, _ := svc.task(other)
+
+					if aws.StringValue(task.LastStatus) == TASK_STATUS_STOPPED {
+						t.Fatalf("Expected task %s not to be stopped", other)
+					}
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to stop %s, %s", tt.name, err)
+			}
+
+			if len(stopped) != tt.stopped {
+				t.Fatalf("Expected %d stopped tasks, got %d", tt.stopped, len(stopped))
+			}
+
+			for _, d := range stopped {
+
+				if d.Task.Status != TASK_STATUS_STOPPED {
+					t.Fatalf("Expected task %s to be stopped, got '%s'", d.Task.TaskId, d.Task.Status)
+				}
+
+				if d.Task.JobId != "iiif-a" {
+					t.Fatalf("Expected task %s to be for job iiif-a, got '%s'", d.Task.TaskId, d.Task.JobId)
+				}
+			}
+
+			// other jobs are left alone
+
+			_, task, _ := svc.task(b)
+
+			if aws.StringValue(task.LastStatus) == TASK_STATUS_STOPPED {
+				t.Fatalf("Expected task %s not to be stopped", b)
+			}
+		})
+	}
+}
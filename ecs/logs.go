@@ -0,0 +1,221 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"strings"
+	"time"
+)
+
+// LogsService is the subset of the AWS CloudWatch Logs API used to read the output of
+// processing tasks. It is satisfied by *cloudwatchlogs.CloudWatchLogs and by FakeLogsService.
+type LogsService interface {
+	GetLogEvents(*cloudwatchlogs.GetLogEventsInput) (*cloudwatchlogs.GetLogEventsOutput, error)
+}
+
+type LogStream struct {
+	Group  string
+	Stream string
+}
+
+type PaletteColour struct {
+	Name      string `json:"name"`
+	Hex       string `json:"hex"`
+	Reference string `json:"reference"`
+}
+
+// ProcessResult is the record that iiif-process emits for each URI it processes.
+type ProcessResult struct {
+	Dimensions map[string][]int  `json:"dimensions"`
+	Palette    []*PaletteColour  `json:"palette,omitempty"`
+	URIs       map[string]string `json:"uris"`
+}
+
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/using_awslogs.html
+
+func LogStreamForTask(svc ECSService, task *aws_ecs.Task, container string) (*LogStream, error) {
+
+	input := &aws_ecs.DescribeTaskDefinitionInput{
+		TaskDefinition: task.TaskDefinitionArn,
+	}
+
+	rsp, err := svc.DescribeTaskDefinition(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var def *aws_ecs.ContainerDefinition
+
+	for _, d := range rsp.TaskDefinition.ContainerDefinitions {
+
+		if aws.StringValue(d.Name) == container {
+			def = d
+			break
+		}
+	}
+
+	if def == nil {
+		msg := fmt.Sprintf("Task definition has no container named %s", container)
+		return nil, errors.New(msg)
+	}
+
+	cfg := def.LogConfiguration
+
+	if cfg == nil || aws.StringValue(cfg.LogDriver) != aws_ecs.LogDriverAwslogs {
+		msg := fmt.Sprintf("Container %s is not configured to use the awslogs log driver", container)
+		return nil, errors.New(msg)
+	}
+
+	group := aws.StringValue(cfg.Options["awslogs-group"])
+	prefix := aws.StringValue(cfg.Options["awslogs-stream-prefix"])
+
+	if group == "" || prefix == "" {
+		msg := fmt.Sprintf("Container %s is missing an awslogs-group or awslogs-stream-prefix option", container)
+		return nil, errors.New(msg)
+	}
+
+	arn := aws.StringValue(task.TaskArn)
+	task_id := arn[strings.LastIndex(arn, "/")+1:]
+
+	s := LogStream{
+		Group:  group,
+		Stream: fmt.Sprintf("%s/%s/%s", prefix, container, task_id),
+	}
+
+	return &s, nil
+}
+
+// ReadLogEvents pages through a log stream, starting at the beginning or at next_token if
+// it is not empty, and returns all the events it finds along with the token to use to
+// read any events written after this call.
+func ReadLogEvents(svc LogsService, s *LogStream, next_token string) ([]*cloudwatchlogs.OutputLogEvent, string, error) {
+
+	events := make([]*cloudwatchlogs.OutputLogEvent, 0)
+
+	for {
+
+		input := &cloudwatchlogs.GetLogEventsInput{
+			LogGroupName:  aws.String(s.Group),
+			LogStreamName: aws.String(s.Stream),
+			StartFromHead: aws.Bool(true),
+		}
+
+		if next_token != "" {
+			input.NextToken = aws.String(next_token)
+		}
+
+		rsp, err := svc.GetLogEvents(input)
+
+		if err != nil {
+			return nil, next_token, err
+		}
+
+		for _, e := range rsp.Events {
+			events = append(events, e)
+		}
+
+		// "If you have reached the end of the stream, it returns the same
+		// token you passed in."
+
+		token := aws.StringValue(rsp.NextForwardToken)
+
+		if token == "" || token == next_token {
+			break
+		}
+
+		next_token = token
+	}
+
+	return events, next_token, nil
+}
+
+// ParseProcessResults collects every log message that looks like the JSON output of
+// iiif-process and merges them in to a single dictionary keyed by URI. Anything else
+// (warnings, debugging output and so on) is ignored.
+func ParseProcessResults(events []*cloudwatchlogs.OutputLogEvent) map[string]*ProcessResult {
+
+	results := make(map[string]*ProcessResult)
+
+	for _, e := range events {
+
+		msg := strings.TrimSpace(aws.StringValue(e.Message))
+
+		if !strings.HasPrefix(msg, "{") {
+			continue
+		}
+
+		var rsp map[string]*ProcessResult
+
+		err := json.Unmarshal([]byte(msg), &rsp)
+
+		if err != nil {
+			continue
+		}
+
+		for k, v := range rsp {
+
+			if v == nil || v.URIs == nil {
+				continue
+			}
+
+			results[k] = v
+		}
+	}
+
+	return results
+}
+
+func (l *ProcessTaskLauncher) readProcessResults(task *aws_ecs.Task, container string) (map[string]*ProcessResult, error) {
+
+	s, err := LogStreamForTask(l.service, task, container)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// log events can take a few seconds to show up in CloudWatch after
+	// the task has stopped so try a few times before giving up
+
+	var events []*cloudwatchlogs.OutputLogEvent
+
+	for i := 0; i < 5; i++ {
+
+		if i > 0 {
+			time.Sleep(l.poll_interval)
+		}
+
+		events, _, err = ReadLogEvents(l.logs, s, "")
+
+		if err != nil {
+
+			if isResourceNotFound(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		if len(events) > 0 {
+			break
+		}
+	}
+
+	return ParseProcessResults(events), nil
+}
+
+func isResourceNotFound(err error) bool {
+
+	aws_err, ok := err.(awserr.Error)
+
+	if !ok {
+		return false
+	}
+
+	return aws_err.Code() == cloudwatchlogs.ErrCodeResourceNotFoundException
+}
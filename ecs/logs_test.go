@@ -0,0 +1,99 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"testing"
+	"time"
+)
+
+func TestParseProcessResults(t *testing.T) {
+
+	messages := []string{
+		"2019/12/09 21:10:03 Processing zuber.jpg",
+		`{"zuber.jpg":{"dimensions":{"b":[320,240]},"palette":[{"name":"black","hex":"#000000","reference":"crayola"}],"uris":{"b":"file:///zuber/full/320,/0/color.jpg"}}}`,
+		`  {"avocado.png":{"dimensions":{},"uris":{"o":"file:///avocado/full/full/0/color.png"}}}  `,
+		`{"broken.jpg":`,
+		`{"empty.jpg":null,"no-uris.jpg":{"dimensions":{}}}`,
+		`["not","a","result"]`,
+	}
+
+	events := make([]*cloudwatchlogs.OutputLogEvent, len(messages))
+
+	for i, m := range messages {
+		events[i] = &cloudwatchlogs.OutputLogEvent{
+			Message: aws.String(m),
+		}
+	}
+
+	results := ParseProcessResults(events)
+
+	if len(results) != 2 {
+		t.Fatalf("Expected 2 results, got %d: %v", len(results), results)
+	}
+
+	zuber, ok := results["zuber.jpg"]
+
+	if !ok {
+		t.Fatal("Missing results for zuber.jpg")
+	}
+
+	dims := zuber.Dimensions["b"]
+
+	if len(dims) != 2 || dims[0] != 320 || dims[1] != 240 {
+		t.Fatalf("Unexpected dimensions for zuber.jpg %v", zuber.Dimensions)
+	}
+
+	if len(zuber.Palette) != 1 || zuber.Palette[0].Hex != "#000000" {
+		t.Fatalf("Unexpected palette for zuber.jpg %v", zuber.Palette)
+	}
+
+	avocado, ok := results["avocado.png"]
+
+	if !ok {
+		t.Fatal("Missing results for avocado.png")
+	}
+
+	if avocado.URIs["o"] != "file:///avocado/full/full/0/color.png" {
+		t.Fatalf("Unexpected URIs for avocado.png %v", avocado.URIs)
+	}
+}
+
+func TestLaunchProcessTaskWaitResults(t *testing.T) {
+
+	svc := NewFakeECSService()
+	logs := NewFakeLogsService()
+
+	// the fake names tasks after the order they are launched in
+
+	stream := fmt.Sprintf("%s/%s/%032d", FAKE_LOG_STREAM_PREFIX, "iiif-process", 1)
+
+	logs.AddLogEvents(FAKE_LOG_GROUP, stream,
+		"2019/12/09 21:10:03 Processing zuber.jpg",
+		`{"zuber.jpg":{"dimensions":{"b":[320,240]},"uris":{"b":"file:///zuber/full/320,/0/color.jpg"}}}`,
+	)
+
+	l := NewProcessTaskLauncher(svc, logs)
+	l.SetPollInterval(time.Millisecond)
+
+	opts := newTestProcessTaskOptions(t, "file:///zuber.jpg?target=zuber")
+	opts.Wait = true
+
+	rsp, err := l.LaunchProcessTask(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	result, ok := rsp.Results["zuber.jpg"]
+
+	if !ok {
+		t.Fatalf("Expected results for zuber.jpg, got %v", rsp.Results)
+	}
+
+	if result.URIs["b"] != "file:///zuber/full/320,/0/color.jpg" {
+		t.Fatalf("Unexpected URIs %v", result.URIs)
+	}
+}
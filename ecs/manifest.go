@@ -0,0 +1,183 @@
+package ecs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/go-iiif/go-iiif-uri"
+	"strings"
+)
+
+// The wrapper that reads the URIs for a task from its manifest and then runs iiif-process
+// with them (see cmd/iiif-process-manifest).
+const MANIFEST_WRAPPER string = "/bin/iiif-process-manifest"
+
+// The environment variables that the location of a task's manifest is passed to MANIFEST_WRAPPER in.
+const MANIFEST_SOURCE_ENV_VAR string = "IIIF_PROCESS_MANIFEST_SOURCE"
+
+const MANIFEST_NAME_ENV_VAR string = "IIIF_PROCESS_MANIFEST_NAME"
+
+// ManifestRecord is a single line in a (JSONL) URI manifest.
+type ManifestRecord struct {
+	URI string `json:"uri"`
+}
+
+// NewManifest returns a JSONL manifest of uris, one ManifestRecord per line.
+func NewManifest(uris []uri.URI) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+
+	for _, u := range uris {
+
+		err := enc.Encode(&ManifestRecord{URI: u.String()})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseManifest returns the URI strings in a JSONL manifest. Empty lines are ignored.
+func ParseManifest(body []byte) ([]string, error) {
+
+	uris := make([]string, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+
+	ln := 0
+
+	for scanner.Scan() {
+
+		ln += 1
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		var r *ManifestRecord
+
+		err := json.Unmarshal([]byte(line), &r)
+
+		if err != nil {
+			msg := fmt.Sprintf("Invalid manifest record at line %d, %s", ln, err)
+			return nil, errors.New(msg)
+		}
+
+		if r == nil || r.URI == "" {
+			msg := fmt.Sprintf("Invalid manifest record at line %d, missing uri", ln)
+			return nil, errors.New(msg)
+		}
+
+		uris = append(uris, r.URI)
+	}
+
+	err := scanner.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return uris, nil
+}
+
+// StageManifest uploads a manifest of uris for job_id and returns its name.
+func (s *Staging) StageManifest(ctx context.Context, job_id string, uris []uri.URI) (string, error) {
+
+	body, err := NewManifest(uris)
+
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("%s%s/manifest-%x.jsonl", s.prefix, job_id, sha256.Sum256(body))
+
+	err = s.put(ctx, name, body, "application/x-ndjson")
+
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// ReadManifest returns the URI strings in the staged manifest named name.
+func (s *Staging) ReadManifest(ctx context.Context, name string) ([]string, error) {
+
+	body, err := s.get(ctx, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseManifest(body)
+}
+
+// stageManifest uploads a manifest of opts.URIs to opts.Staging, if opts.Manifest is true, and
+// returns its name.
+func stageManifest(ctx context.Context, opts *ProcessTaskOptions) (string, error) {
+
+	if !opts.Manifest {
+		return "", nil
+	}
+
+	if opts.Staging == nil {
+		return "", errors.New("Manifests require a staging bucket")
+	}
+
+	return opts.Staging.StageManifest(ctx, opts.JobId, opts.URIs)
+}
+
+// TaskManifest returns the source and name of the manifest that container in task was launched
+// with, or empty strings if it wasn't launched with one.
+func TaskManifest(task *aws_ecs.Task, container string) (string, string) {
+
+	if task.Overrides == nil {
+		return "", ""
+	}
+
+	source := ""
+	name := ""
+
+	for _, o := range task.Overrides.ContainerOverrides {
+
+		if aws.StringValue(o.Name) != container {
+			continue
+		}
+
+		for _, kv := range o.Environment {
+
+			switch aws.StringValue(kv.Name) {
+			case MANIFEST_SOURCE_ENV_VAR:
+				source = aws.StringValue(kv.Value)
+			case MANIFEST_NAME_ENV_VAR:
+				name = aws.StringValue(kv.Value)
+			}
+		}
+	}
+
+	return source, name
+}
+
+// isProcessCommand returns true if cmd runs iiif-process, either directly or by way of MANIFEST_WRAPPER.
+func isProcessCommand(cmd []string) bool {
+
+	if len(cmd) > 1 && cmd[0] == MANIFEST_WRAPPER {
+		cmd = cmd[1:]
+	}
+
+	return len(cmd) > 0 && cmd[0] == "/bin/iiif-process"
+}
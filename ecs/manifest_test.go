@@ -0,0 +1,159 @@
+package ecs
+
+import (
+	"context"
+	"github.com/go-iiif/go-iiif-uri"
+	"strings"
+	"testing"
+)
+
+func TestManifestRoundTrip(t *testing.T) {
+
+	str_uris := []string{
+		"file:///zuber.jpg",
+		"file:///my%20photo%281%29.jpg",
+		"file:///zuber%3Fv=1.jpg",
+		"idsecret:///zuber.jpg?id=1234&secret=abc&secret_o=def&format=png&label=b",
+		"rewrite:///zuber.jpg?target=zuber%20copy.jpg",
+	}
+
+	uris := make([]uri.URI, len(str_uris))
+
+	for i, str_uri := range str_uris {
+
+		u, err := ParseURI(str_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to parse %s, %s", str_uri, err)
+		}
+
+		uris[i] = u
+	}
+
+	body, err := NewManifest(uris)
+
+	if err != nil {
+		t.Fatalf("Failed to create manifest, %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+
+	if len(lines) != len(str_uris) {
+		t.Fatalf("Expected %d lines, got %d", len(str_uris), len(lines))
+	}
+
+	// URIs are not HTML-escaped
+
+	if strings.Contains(string(body), `\u0026`) {
+		t.Fatalf("Expected manifest not to escape '&', got %s", string(body))
+	}
+
+	parsed, err := ParseManifest(body)
+
+	if err != nil {
+		t.Fatalf("Failed to parse manifest, %s", err)
+	}
+
+	if len(parsed) != len(str_uris) {
+		t.Fatalf("Expected %d URIs, got %d", len(str_uris), len(parsed))
+	}
+
+	for i, str_uri := range str_uris {
+
+		if parsed[i] != str_uri {
+			t.Fatalf("Expected URI %s, got %s", str_uri, parsed[i])
+		}
+	}
+}
+
+func TestParseManifest(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		manifest string
+		expected int
+		valid    bool
+	}{
+		{"empty", "", 0, true},
+		{"one", `{"uri": "file:///zuber.jpg"}`, 1, true},
+		{"blank lines", "\n{\"uri\": \"file:///a.jpg\"}\n\n  \n{\"uri\": \"file:///b.jpg\"}\n", 2, true},
+		{"missing uri", `{"url": "file:///zuber.jpg"}`, 0, false},
+		{"empty uri", `{"uri": ""}`, 0, false},
+		{"null", `null`, 0, false},
+		{"invalid", "{\"uri\": \"file:///a.jpg\"}\n{\"uri\":", 0, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			uris, err := ParseManifest([]byte(tt.manifest))
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected manifest to be valid, %s", err)
+			}
+
+			if !tt.valid {
+
+				if err == nil {
+					t.Fatalf("Expected manifest to be invalid, got %v", uris)
+				}
+
+				return
+			}
+
+			if len(uris) != tt.expected {
+				t.Fatalf("Expected %d URIs, got %d", tt.expected, len(uris))
+			}
+		})
+	}
+}
+
+func TestStageManifest(t *testing.T) {
+
+	svc := newFakeS3Service()
+
+	s, err := NewStaging(svc, "s3://staging", DEFAULT_STAGING_PREFIX)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging, %s", err)
+	}
+
+	u, err := ParseURI("file:///my%20photo.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to parse URI, %s", err)
+	}
+
+	ctx := context.Background()
+
+	name, err := s.StageManifest(ctx, "iiif-test", []uri.URI{u})
+
+	if err != nil {
+		t.Fatalf("Failed to stage manifest, %s", err)
+	}
+
+	if !strings.HasPrefix(name, "iiif-process/iiif-test/manifest-") || !strings.HasSuffix(name, ".jsonl") {
+		t.Fatalf("Unexpected name %s", name)
+	}
+
+	if !s.IsStaged("s3://staging", name) {
+		t.Fatalf("Expected %s to be staged", name)
+	}
+
+	str_uris, err := s.ReadManifest(ctx, name)
+
+	if err != nil {
+		t.Fatalf("Failed to read manifest, %s", err)
+	}
+
+	if len(str_uris) != 1 || str_uris[0] != u.String() {
+		t.Fatalf("Expected manifest to contain %s, got %v", u.String(), str_uris)
+	}
+
+	_, err = s.ReadManifest(ctx, "iiif-process/iiif-test/missing.jsonl")
+
+	if err == nil {
+		t.Fatal("Expected missing manifest to fail")
+	}
+}
@@ -0,0 +1,50 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"strings"
+)
+
+// assignPublicIp returns the (normalized) value of opts.AssignPublicIp, defaulting to
+// ENABLED which is how tasks have always been launched.
+func assignPublicIp(opts *ProcessTaskOptions) (string, error) {
+
+	public_ip := strings.ToUpper(strings.TrimSpace(opts.AssignPublicIp))
+
+	switch public_ip {
+	case "":
+		return aws_ecs.AssignPublicIpEnabled, nil
+	case aws_ecs.AssignPublicIpEnabled, aws_ecs.AssignPublicIpDisabled:
+		return public_ip, nil
+	default:
+		msg := fmt.Sprintf("Invalid assign public IP value '%s'", opts.AssignPublicIp)
+		return "", errors.New(msg)
+	}
+}
+
+// NetworkWarnings returns a list of (non-fatal) problems with the network configuration
+// in opts. A task without a public IP address can only reach S3, ECR and CloudWatch if
+// it runs in a private subnet with a NAT gateway or VPC endpoints so if you're not
+// explicit about which subnets and security groups to use it will probably not work.
+func NetworkWarnings(opts *ProcessTaskOptions) []string {
+
+	warnings := make([]string, 0)
+
+	public_ip, err := assignPublicIp(opts)
+
+	if err != nil || public_ip != aws_ecs.AssignPublicIpDisabled {
+		return warnings
+	}
+
+	if len(opts.Subnets) == 0 {
+		warnings = append(warnings, "Public IP is disabled but no subnets were specified; the task will need a NAT gateway or VPC endpoints to reach S3")
+	}
+
+	if len(opts.SecurityGroups) == 0 {
+		warnings = append(warnings, "Public IP is disabled but no security groups were specified; the task will use the VPC's default security group")
+	}
+
+	return warnings
+}
@@ -0,0 +1,126 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/whosonfirst/go-whosonfirst-aws/session"
+	"strings"
+)
+
+// Notifier is used to announce that a task has completed. Notifiers send results with any
+// secrets in their URIs redacted (see JobResult.Redacted).
+type Notifier interface {
+	Notify(context.Context, *JobResult) error
+}
+
+// NewNotifier returns a Notifier for target, which may be an HTTP(S) URL (see WebhookNotifier)
+// or an SNS topic ARN (see SNSNotifier) in which case dsn is used to create the SNS client.
+func NewNotifier(target string, dsn string) (Notifier, error) {
+
+	if strings.HasPrefix(target, "http://") || strings.HasPrefix(target, "https://") {
+		return NewWebhookNotifier(target), nil
+	}
+
+	if strings.HasPrefix(target, "arn:aws:sns:") {
+		return NewSNSNotifierWithDSN(dsn, target)
+	}
+
+	msg := fmt.Sprintf("Unsupported notification target '%s'", target)
+	return nil, errors.New(msg)
+}
+
+// WebhookNotifier POSTs a JSON-encoded JobResult to a URL (see PostJobResult).
+type WebhookNotifier struct {
+	url string
+}
+
+var _ Notifier = (*WebhookNotifier)(nil)
+
+func NewWebhookNotifier(url string) Notifier {
+
+	n := WebhookNotifier{
+		url: url,
+	}
+
+	return &n
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, result *JobResult) error {
+	return PostJobResult(ctx, n.url, result.Redacted())
+}
+
+type SNSService interface {
+	PublishWithContext(aws.Context, *sns.PublishInput, ...request.Option) (*sns.PublishOutput, error)
+}
+
+// SNSNotifier publishes a JSON-encoded JobResult to an SNS topic. The job ID (if there is one)
+// and the task status are also included as the "job_id" and "status" message attributes so that
+// subscriptions can filter on them.
+type SNSNotifier struct {
+	service SNSService
+	topic   string
+}
+
+var _ Notifier = (*SNSNotifier)(nil)
+
+func NewSNSNotifierWithDSN(dsn string, topic string) (Notifier, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := sns.New(sess)
+
+	return NewSNSNotifier(svc, topic), nil
+}
+
+func NewSNSNotifier(svc SNSService, topic string) Notifier {
+
+	n := SNSNotifier{
+		service: svc,
+		topic:   topic,
+	}
+
+	return &n
+}
+
+func (n *SNSNotifier) Notify(ctx context.Context, result *JobResult) error {
+
+	enc, err := json.Marshal(result.Redacted())
+
+	if err != nil {
+		return err
+	}
+
+	attrs := make(map[string]*sns.MessageAttributeValue)
+
+	if result.JobId != "" {
+		attrs["job_id"] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(result.JobId),
+		}
+	}
+
+	if result.Task != nil && result.Task.Status != "" {
+		attrs["status"] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(result.Task.Status),
+		}
+	}
+
+	input := &sns.PublishInput{
+		TopicArn:          aws.String(n.topic),
+		Message:           aws.String(string(enc)),
+		MessageAttributes: attrs,
+	}
+
+	_, err = n.service.PublishWithContext(ctx, input)
+	return err
+}
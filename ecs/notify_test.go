@@ -0,0 +1,106 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/go-iiif/go-iiif-uri"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeSNSService records the messages it is asked to publish.
+type fakeSNSService struct {
+	Inputs []*sns.PublishInput
+}
+
+func (svc *fakeSNSService) PublishWithContext(ctx aws.Context, input *sns.PublishInput, opts ...request.Option) (*sns.PublishOutput, error) {
+	svc.Inputs = append(svc.Inputs, input)
+	return &sns.PublishOutput{}, nil
+}
+
+func newTestSecretJobResult(t *testing.T) *JobResult {
+
+	u, err := ParseURI("idsecret:///zuber.jpg?id=1234&secret=abc&secret_o=def")
+
+	if err != nil {
+		t.Fatalf("Failed to parse URI, %s", err)
+	}
+
+	result := &JobResult{
+		JobId: "iiif-test",
+		Task: &ProcessTaskResponse{
+			TaskId: "task",
+			Status: TASK_STATUS_STOPPED,
+			URIs:   []uri.URI{u},
+		},
+	}
+
+	return result
+}
+
+func TestNotifiersRedactSecrets(t *testing.T) {
+
+	bodies := make(chan string, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		body, err := ioutil.ReadAll(req.Body)
+
+		if err != nil {
+			t.Errorf("Failed to read request, %s", err)
+		}
+
+		bodies <- string(body)
+	}))
+
+	defer server.Close()
+
+	sns_svc := &fakeSNSService{}
+
+	tests := []struct {
+		name     string
+		notifier Notifier
+		body     func() string
+	}{
+		{"webhook", NewWebhookNotifier(server.URL), func() string { return <-bodies }},
+		{"sns", NewSNSNotifier(sns_svc, "arn:aws:sns:us-east-1:000000000000:iiif"), func() string { return aws.StringValue(sns_svc.Inputs[len(sns_svc.Inputs)-1].Message) }},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			result := newTestSecretJobResult(t)
+
+			err := tt.notifier.Notify(context.Background(), result)
+
+			if err != nil {
+				t.Fatalf("Failed to notify, %s", err)
+			}
+
+			var sent *JobResult
+
+			err = json.Unmarshal([]byte(tt.body()), &sent)
+
+			if err != nil {
+				t.Fatalf("Failed to decode notification, %s", err)
+			}
+
+			expected := "idsecret:///zuber.jpg?id=1234&secret=REDACTED&secret_o=REDACTED"
+
+			if sent.JobId != result.JobId || len(sent.Task.URIs) != 1 || sent.Task.URIs[0].String() != expected {
+				t.Fatalf("Expected notification for %s, got %v", expected, sent.Task.URIs)
+			}
+
+			if !strings.Contains(result.Task.URIs[0].String(), "secret=abc") {
+				t.Fatal("Expected the original result to be unchanged")
+			}
+		})
+	}
+}
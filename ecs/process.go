@@ -4,46 +4,231 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"fmt"
 	aws_events "github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
 	"github.com/go-iiif/go-iiif-uri"
-	"github.com/whosonfirst/go-whosonfirst-aws/lambda"
 	"github.com/whosonfirst/go-whosonfirst-aws/session"
+	"io"
 	"log"
-	"mime"
-	"path/filepath"
-	"strings"
+	"strconv"
+	"time"
 )
 
 type ProcessTaskOptions struct {
-	DSN            string
-	Task           string
-	Wait           bool
-	Cluster        string
-	Container      string
-	SecurityGroups []string
-	Subnets        []string
-	Config         string
-	Report         bool
-	ReportName     string
-	Instructions   string
-	URIs           []uri.URI
+	DSN               string
+	Task              string
+	Wait              bool
+	Follow            bool
+	FollowWriter      io.Writer
+	Cluster           string
+	Container         string
+	LaunchType        string
+	CapacityProviders []*CapacityProvider
+	PlatformVersion   string
+	CPU               int64
+	Memory            int64
+	SizingPolicy      *SizingPolicy
+	Retry             *RetryOptions
+	Sources           *SourceMap
+	Filter            *EventFilter
+	SecurityGroups    []string
+	AssignPublicIp    string
+	Subnets           []string
+	Config            string
+	Report            bool
+	ReportName        string
+	Instructions      string
+	// ConfigSource is a Go Cloud bucket URI containing the IIIF config named ConfigName. If set it
+	// is used instead of Config.
+	ConfigSource string
+	ConfigName   string
+	// InstructionsSource is a Go Cloud bucket URI containing the IIIF instructions named InstructionsName.
+	// If set it is used instead of Instructions.
+	InstructionsSource string
+	InstructionsName   string
+	// InstructionsDocument is a JSON-encoded instructions document that is uploaded to Staging
+	// when a task is launched. If set it is used instead of Instructions and InstructionsSource.
+	InstructionsDocument []byte
+	Staging              *Staging
+	// If true URIs are written to a manifest in Staging, rather than being passed to iiif-process
+	// on the command line, and read back by MANIFEST_WRAPPER in the container.
+	Manifest bool
+	URIs     []uri.URI
+	JobId    string
+	Callback string
+	// CallbackHosts are the hosts (host or host:port) that the callback URLs in job requests may
+	// point to. If empty any host is allowed.
+	CallbackHosts []string
+	Store         JobStore
+	Group         string
+	Tags          map[string]string
+	// Trigger is recorded in the TRIGGER_TAG tag, for example "lambda" or "worker".
+	Trigger string
 }
 
 type ProcessTaskResponse struct {
-	TaskId string
-	URIs   []uri.URI
+	JobId         string `json:",omitempty"`
+	TaskId        string
+	URIs          []uri.URI
+	Status        string                    `json:",omitempty"`
+	CreatedAt     *time.Time                `json:",omitempty"`
+	StoppedReason string                    `json:",omitempty"`
+	StartedAt     *time.Time                `json:",omitempty"`
+	StoppedAt     *time.Time                `json:",omitempty"`
+	Containers    []*ContainerStatus        `json:",omitempty"`
+	Results       map[string]*ProcessResult `json:",omitempty"`
 }
 
 func (t *ProcessTaskResponse) String() string {
 	return t.TaskId
 }
 
+// Redacted returns a copy of t whose URIs, and the keys of its Results, have had their secrets
+// redacted (see RedactURI). Derivative URIs in Results are left as is.
+func (t *ProcessTaskResponse) Redacted() *ProcessTaskResponse {
+
+	r := *t
+	r.URIs = make([]uri.URI, len(t.URIs))
+
+	for i, u := range t.URIs {
+		r.URIs[i] = redactURI(u)
+	}
+
+	if t.Results != nil {
+
+		r.Results = make(map[string]*ProcessResult)
+
+		for str_uri, result := range t.Results {
+			r.Results[RedactURI(str_uri)] = result
+		}
+	}
+
+	return &r
+}
+
+// processTaskResponseAlias is used to (un)marshal ProcessTaskResponse without recursing
+// in to its own MarshalJSON and UnmarshalJSON methods.
+type processTaskResponseAlias ProcessTaskResponse
+
+// MarshalJSON encodes URIs as strings, since uri.URI is an interface whose implementations
+// don't have any exported properties.
+func (t *ProcessTaskResponse) MarshalJSON() ([]byte, error) {
+
+	str_uris := make([]string, len(t.URIs))
+
+	for i, u := range t.URIs {
+		str_uris[i] = u.String()
+	}
+
+	enc := struct {
+		*processTaskResponseAlias
+		URIs []string
+	}{
+		processTaskResponseAlias: (*processTaskResponseAlias)(t),
+		URIs:                     str_uris,
+	}
+
+	return json.Marshal(enc)
+}
+
+func (t *ProcessTaskResponse) UnmarshalJSON(body []byte) error {
+
+	dec := struct {
+		*processTaskResponseAlias
+		URIs []string
+	}{
+		processTaskResponseAlias: (*processTaskResponseAlias)(t),
+	}
+
+	err := json.Unmarshal(body, &dec)
+
+	if err != nil {
+		return err
+	}
+
+	uris := make([]uri.URI, len(dec.URIs))
+
+	for i, str_uri := range dec.URIs {
+
+		u, err := ParseURI(str_uri)
+
+		if err != nil {
+			return err
+		}
+
+		uris[i] = u
+	}
+
+	t.URIs = uris
+	return nil
+}
+
+type ProcessTaskLauncher struct {
+	service       ECSService
+	logs          LogsService
+	poll_interval time.Duration
+}
+
+// NewProcessTaskLauncher returns a ProcessTaskLauncher for svc. If logs is not nil it
+// will be used to read the output of iiif-process from CloudWatch after waiting for
+// a task to complete.
+func NewProcessTaskLauncher(svc ECSService, logs LogsService) *ProcessTaskLauncher {
+
+	l := ProcessTaskLauncher{
+		service:       svc,
+		logs:          logs,
+		poll_interval: 5 * time.Second,
+	}
+
+	return &l
+}
+
+func NewProcessTaskLauncherWithDSN(dsn string) (*ProcessTaskLauncher, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := aws_ecs.New(sess)
+	logs := cloudwatchlogs.New(sess)
+
+	return NewProcessTaskLauncher(svc, logs), nil
+}
+
+// SetPollInterval sets how long to wait between successive polls of a task's status or log
+// stream. The default is 5 seconds.
+func (l *ProcessTaskLauncher) SetPollInterval(d time.Duration) {
+	l.poll_interval = d
+}
+
 func LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessTaskResponse, error) {
 
-	sess, err := session.NewSessionWithDSN(opts.DSN)
+	l, err := NewProcessTaskLauncherWithDSN(opts.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return l.LaunchProcessTask(ctx, opts)
+}
+
+// ProcessCommand returns the command used to invoke iiif-process, in the container, for
+// the URIs in opts. An error is returned if any of those URIs are not images (see ValidateURI)
+// or if the IIIF config or instructions are missing. If opts.Manifest is true the URIs are
+// not included.
+func ProcessCommand(opts *ProcessTaskOptions) ([]*string, error) {
+
+	config_args, err := configArgs(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	instructions_args, err := instructionsArgs(opts)
 
 	if err != nil {
 		return nil, err
@@ -51,12 +236,15 @@ func LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessT
 
 	cmd := []*string{
 		aws.String("/bin/iiif-process"),
-		aws.String("-config"),
-		aws.String(opts.Config),
-		aws.String("-instructions"),
-		aws.String(opts.Instructions),
 	}
 
+	if opts.Manifest {
+		cmd = append([]*string{aws.String(MANIFEST_WRAPPER)}, cmd...)
+	}
+
+	cmd = append(cmd, aws.StringSlice(config_args)...)
+	cmd = append(cmd, aws.StringSlice(instructions_args)...)
+
 	if opts.Report {
 		cmd = append(cmd, aws.String("-report"))
 		cmd = append(cmd, aws.String("-report-name"))
@@ -67,21 +255,12 @@ func LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessT
 
 	for _, im := range opts.URIs {
 
-		url, err := im.Target(nil)
+		err := ValidateURI(im)
 
 		if err != nil {
 			return nil, err
 		}
 
-		url_base := filepath.Base(url)
-		url_ext := filepath.Ext(url_base)
-		url_type := mime.TypeByExtension(url_ext)
-
-		if !strings.HasPrefix(url_type, "image/") {
-			msg := fmt.Sprintf("%s has unknown or invalid mime-type %s", url, url_type)
-			return nil, errors.New(msg)
-		}
-
 		images = append(images, im.String())
 	}
 
@@ -89,11 +268,52 @@ func LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessT
 		return nil, errors.New("No images to process")
 	}
 
+	if opts.Manifest {
+		return cmd, nil
+	}
+
 	for _, im := range images {
 		cmd = append(cmd, aws.String("-uri"))
 		cmd = append(cmd, aws.String(im))
 	}
 
+	return cmd, nil
+}
+
+// LaunchProcessTask launches a task to process opts.URIs. If opts.InstructionsDocument is set it
+// is staged first and, if opts.Staging.Cleanup is true, removed once the task has stopped when
+// waiting for it (otherwise see TaskStateChangeLambdaHandlerFunc) or if it failed to launch.
+func (l *ProcessTaskLauncher) LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessTaskResponse, error) {
+
+	opts, err := withJobId(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts, staged, err := stageInstructions(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	task_rsp, err := l.launchProcessTask(ctx, opts)
+
+	if task_rsp == nil || opts.Wait || opts.Follow {
+		cleanupInstructions(ctx, opts, staged)
+	}
+
+	return task_rsp, err
+}
+
+func (l *ProcessTaskLauncher) launchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessTaskResponse, error) {
+
+	cmd, err := ProcessCommand(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
 	/*
 		str_cmd := make([]string, len(cmd))
 
@@ -108,13 +328,33 @@ func LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessT
 	// that follows - it's pretty much boilerplate AWS ECS invoking
 	// code
 
-	svc := aws_ecs.New(sess)
-
 	cluster := aws.String(opts.Cluster)
 	task := aws.String(opts.Task)
 
-	launch_type := aws.String("FARGATE")
-	public_ip := aws.String("ENABLED")
+	launch_type, capacity_providers, is_fargate, err := launchConfiguration(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	str_public_ip, err := assignPublicIp(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range NetworkWarnings(opts) {
+		log.Printf("[WARNING] %s\n", w)
+	}
+
+	// assigning a public IP address is not supported for tasks that
+	// run on EC2 instances
+
+	var public_ip *string
+
+	if is_fargate {
+		public_ip = aws.String(str_public_ip)
+	}
 
 	subnets := make([]*string, len(opts.Subnets))
 	security_groups := make([]*string, len(opts.SecurityGroups))
@@ -135,153 +375,189 @@ func LaunchProcessTask(ctx context.Context, opts *ProcessTaskOptions) (*ProcessT
 		},
 	}
 
+	manifest, err := stageManifest(ctx, opts)
+
+	if err != nil {
+		return nil, err
+	}
+
 	process_override := &aws_ecs.ContainerOverride{
 		Name:    aws.String(opts.Container),
 		Command: cmd,
+		Environment: []*aws_ecs.KeyValuePair{
+			&aws_ecs.KeyValuePair{
+				Name:  aws.String(JOB_ID_ENV_VAR),
+				Value: aws.String(opts.JobId),
+			},
+		},
+	}
+
+	if manifest != "" {
+
+		process_override.Environment = append(process_override.Environment,
+			&aws_ecs.KeyValuePair{
+				Name:  aws.String(MANIFEST_SOURCE_ENV_VAR),
+				Value: aws.String(opts.Staging.Source()),
+			},
+			&aws_ecs.KeyValuePair{
+				Name:  aws.String(MANIFEST_NAME_ENV_VAR),
+				Value: aws.String(manifest),
+			},
+		)
 	}
 
 	overrides := &aws_ecs.TaskOverride{
 		ContainerOverrides: []*aws_ecs.ContainerOverride{process_override},
 	}
 
-	input := &aws_ecs.RunTaskInput{
-		Cluster:              cluster,
-		TaskDefinition:       task,
-		LaunchType:           launch_type,
-		NetworkConfiguration: network,
-		Overrides:            overrides,
-	}
+	cpu := opts.CPU
+	memory := opts.Memory
 
-	rsp, err := svc.RunTask(input)
+	if cpu == 0 && memory == 0 && opts.SizingPolicy != nil {
 
-	if err != nil {
-		return nil, err
+		tier := opts.SizingPolicy.TierForURIs(opts.URIs)
+
+		if tier != nil {
+			cpu = tier.CPU
+			memory = tier.Memory
+		}
 	}
 
-	// https://github.com/buildkite/ecs-run-task/blob/master/runner/runner.go#L148-L208
-	// this appears to be how you capture the output of an ECS task?
-	// (20190124/thisisaaronland)
+	if cpu > 0 {
+		overrides.Cpu = aws.String(strconv.FormatInt(cpu, 10))
+		process_override.Cpu = aws.Int64(cpu)
+	}
 
-	if len(rsp.Tasks) == 0 {
-		log.Println(rsp)
-		return nil, errors.New("run task returned no errors... but no tasks")
+	if memory > 0 {
+		overrides.Memory = aws.String(strconv.FormatInt(memory, 10))
+		process_override.Memory = aws.Int64(memory)
 	}
 
-	task_id := rsp.Tasks[0].TaskArn
+	input := &aws_ecs.RunTaskInput{
+		Cluster:                  cluster,
+		TaskDefinition:           task,
+		LaunchType:               launch_type,
+		CapacityProviderStrategy: capacity_providers,
+		NetworkConfiguration:     network,
+		Overrides:                overrides,
+		Tags:                     TaskTags(opts),
+	}
 
-	if opts.Wait {
+	if re_started_by.MatchString(opts.JobId) {
+		input.StartedBy = aws.String(opts.JobId)
+	}
 
-		tasks := []*string{
-			task_id,
-		}
+	if opts.Group != "" {
+		input.Group = aws.String(opts.Group)
+	}
 
-		pending := &aws_ecs.DescribeTasksInput{
-			Cluster: cluster,
-			Tasks:   tasks,
-		}
+	if opts.PlatformVersion != "" {
+		input.PlatformVersion = aws.String(opts.PlatformVersion)
+	}
 
-		err = svc.WaitUntilTasksStopped(pending)
+	rsp, err := l.runTask(ctx, opts, input)
 
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		removeStaged(ctx, opts, manifest)
+		return nil, err
 	}
 
-	task_rsp := ProcessTaskResponse{
+	task_id := rsp.Tasks[0].TaskArn
+
+	task_rsp := &ProcessTaskResponse{
+		JobId:  opts.JobId,
 		TaskId: *task_id,
 		URIs:   opts.URIs,
 	}
 
-	return &task_rsp, nil
-}
+	task_rsp.setTask(rsp.Tasks[0])
 
-func InvokeLambdaHandlerFunc(opts *ProcessTaskOptions, lambda_dsn string, lambda_func string, lambda_type string) (interface{}, error) {
+	l.recordTask(ctx, opts, task_rsp, nil)
 
-	// https://github.com/aws/aws-lambda-go/blob/master/events/s3.go
+	if opts.Wait || opts.Follow {
 
-	svc, err := lambda.NewLambdaServiceWithDSN(lambda_dsn)
+		// note that we return the response along with the error so that
+		// callers can still see what happened
 
-	if err != nil {
-		return nil, err
-	}
+		err = l.waitForTask(ctx, opts, task_rsp)
 
-	s3_records := make([]aws_events.S3EventRecord, len(opts.URIs))
+		l.recordTask(ctx, opts, task_rsp, err)
 
-	for i, u := range opts.URIs {
+		removeStaged(ctx, opts, manifest)
 
-		s3_object := aws_events.S3Object{
-			Key: u.String(),
+		if err != nil {
+			return task_rsp, err
 		}
+	}
 
-		s3_entity := aws_events.S3Entity{
-			Object: s3_object,
-		}
+	return task_rsp, nil
+}
 
-		s3_records[i] = aws_events.S3EventRecord{
-			S3: s3_entity,
-		}
-	}
+// withJobId returns opts, or a copy of opts with a new job ID if it doesn't already have one.
+func withJobId(opts *ProcessTaskOptions) (*ProcessTaskOptions, error) {
 
-	s3_event := aws_events.S3Event{
-		Records: s3_records,
+	if opts.JobId != "" {
+		return opts, ValidateJobId(opts.JobId)
 	}
 
-	rsp, err := lambda.InvokeFunction(svc, lambda_func, lambda_type, s3_event)
+	job_id, err := NewJobId()
 
 	if err != nil {
 		return nil, err
 	}
 
-	return rsp, nil
+	job_opts := *opts
+	job_opts.JobId = job_id
+
+	return &job_opts, nil
 }
 
 func LambdaHandlerFunc(opts *ProcessTaskOptions) func(ctx context.Context, ev aws_events.S3Event) (*ProcessTaskResponse, error) {
 
 	handler := func(ctx context.Context, ev aws_events.S3Event) (*ProcessTaskResponse, error) {
+		return launchS3Event(ctx, opts, ev)
+	}
 
-		uris := make([]uri.URI, 0)
-
-		for _, r := range ev.Records {
+	return handler
+}
 
-			s3_entity := r.S3
-			s3_obj := s3_entity.Object
-			s3_key := s3_obj.Key
+func launchS3Event(ctx context.Context, opts *ProcessTaskOptions, ev aws_events.S3Event) (*ProcessTaskResponse, error) {
 
-			im, err := uri.NewURI(s3_key)
+	tasks, err := S3EventTasks(opts, ev)
 
-			if err != nil {
-				return nil, err
-			}
+	if err != nil {
+		return nil, err
+	}
 
-			url, err := im.Target(nil)
+	if len(tasks) == 0 {
+		return nil, nil
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	// in practice events only ever reference a single bucket so there
+	// will only be one task but if there are more we launch them all and
+	// return the first response. Tasks are checked before any of them are
+	// launched so that an event is either launched or rejected as a whole
 
-			url_ext := filepath.Ext(url)
-			url_type := mime.TypeByExtension(url_ext)
+	for _, task_opts := range tasks {
 
-			if !strings.HasPrefix(url_type, "image/") {
-				continue
-			}
+		err := ValidateCommandSize(task_opts)
 
-			uris = append(uris, im)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		if len(uris) == 0 {
-			return nil, nil
-		}
+	var first_rsp *ProcessTaskResponse
 
-		opts.URIs = uris
+	for _, task_opts := range tasks {
 
-		rsp, err := LaunchProcessTask(ctx, opts)
+		rsp, err := LaunchProcessTask(ctx, task_opts)
 
 		if err != nil {
 			return nil, err
 		}
 
-		enc_rsp, err := json.Marshal(rsp)
+		enc_rsp, err := json.Marshal(rsp.Redacted())
 
 		if err != nil {
 			return nil, err
@@ -289,8 +565,10 @@ func LambdaHandlerFunc(opts *ProcessTaskOptions) func(ctx context.Context, ev aw
 
 		log.Println(string(enc_rsp))
 
-		return rsp, nil
+		if first_rsp == nil {
+			first_rsp = rsp
+		}
 	}
 
-	return handler
+	return first_rsp, nil
 }
@@ -0,0 +1,119 @@
+package ecs
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/go-iiif/go-iiif-uri"
+	"testing"
+)
+
+func newTestProcessTaskOptions(t *testing.T, uris ...string) *ProcessTaskOptions {
+
+	opts := &ProcessTaskOptions{
+		Cluster:      "iiif",
+		Task:         "iiif-process:1",
+		Container:    "iiif-process",
+		Config:       "/etc/go-iiif/config.json",
+		Instructions: "/etc/go-iiif/instructions.json",
+		URIs:         make([]uri.URI, len(uris)),
+	}
+
+	for i, str_uri := range uris {
+
+		u, err := uri.NewURI(str_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to parse %s, %s", str_uri, err)
+		}
+
+		opts.URIs[i] = u
+	}
+
+	return opts
+}
+
+func TestLaunchProcessTaskWait(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		exit_code int64
+		ok        bool
+	}{
+		{"success", 0, true},
+		{"failure", 1, false},
+		{"killed", 137, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			svc := NewFakeECSService()
+			svc.ExitCodes = []int64{tt.exit_code}
+
+			l := NewProcessTaskLauncher(svc, nil)
+
+			opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+			opts.Wait = true
+
+			rsp, err := l.LaunchProcessTask(context.Background(), opts)
+
+			if rsp == nil {
+				t.Fatalf("Expected a response, got error %v", err)
+			}
+
+			if rsp.Status != TASK_STATUS_STOPPED {
+				t.Fatalf("Expected task to be stopped, got '%s'", rsp.Status)
+			}
+
+			if tt.ok {
+
+				if err != nil {
+					t.Fatalf("Expected task to succeed, %s", err)
+				}
+
+				return
+			}
+
+			exit_err, ok := err.(*TaskExitError)
+
+			if !ok {
+				t.Fatalf("Expected a *TaskExitError, got %v", err)
+			}
+
+			if exit_err.ExitCode != tt.exit_code {
+				t.Fatalf("Expected exit code %d, got %d", tt.exit_code, exit_err.ExitCode)
+			}
+		})
+	}
+}
+
+func TestLaunchProcessTaskWaitCancelled(t *testing.T) {
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+
+	rsp, err := l.LaunchProcessTask(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = l.waitForTask(ctx, opts, rsp)
+
+	aws_err, ok := err.(awserr.Error)
+
+	if !ok || aws_err.Code() != request.CanceledErrorCode {
+		t.Fatalf("Expected waiting with a cancelled context to fail with %s, got %v", request.CanceledErrorCode, err)
+	}
+
+	if rsp.Status == TASK_STATUS_STOPPED {
+		t.Fatalf("Expected task not to be stopped")
+	}
+}
@@ -0,0 +1,206 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+// apiGatewayV2Request is the (version 2.0) payload sent by API Gateway HTTP APIs and Lambda
+// function URLs, which predates the version of aws-lambda-go we use.
+type apiGatewayV2Request struct {
+	Version         string            `json:"version"`
+	RawPath         string            `json:"rawPath"`
+	RawQueryString  string            `json:"rawQueryString"`
+	Cookies         []string          `json:"cookies"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+	RequestContext  struct {
+		Stage string `json:"stage"`
+		HTTP  struct {
+			Method string `json:"method"`
+		} `json:"http"`
+	} `json:"requestContext"`
+}
+
+type apiGatewayV2Response struct {
+	StatusCode      int               `json:"statusCode"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+}
+
+// ProxyLambdaHandlerFunc returns a Lambda handler that passes API Gateway proxy events (REST
+// APIs), API Gateway HTTP API events and Lambda function URL events to h.
+func ProxyLambdaHandlerFunc(h http.Handler) func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+
+	handler := func(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+
+		var probe struct {
+			Version string `json:"version"`
+		}
+
+		err := json.Unmarshal(payload, &probe)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if probe.Version == "2.0" {
+
+			var ev apiGatewayV2Request
+
+			err := json.Unmarshal(payload, &ev)
+
+			if err != nil {
+				return nil, err
+			}
+
+			return serveV2Request(ctx, h, &ev)
+		}
+
+		var ev aws_events.APIGatewayProxyRequest
+
+		err = json.Unmarshal(payload, &ev)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return serveProxyRequest(ctx, h, &ev)
+	}
+
+	return handler
+}
+
+func serveProxyRequest(ctx context.Context, h http.Handler, ev *aws_events.APIGatewayProxyRequest) (*aws_events.APIGatewayProxyResponse, error) {
+
+	query := url.Values{}
+
+	for k, values := range ev.MultiValueQueryStringParameters {
+		query[k] = values
+	}
+
+	for k, v := range ev.QueryStringParameters {
+
+		if _, ok := query[k]; !ok {
+			query.Set(k, v)
+		}
+	}
+
+	req, err := newProxyHTTPRequest(ctx, ev.HTTPMethod, ev.Path, query.Encode(), ev.Body, ev.IsBase64Encoded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, values := range ev.MultiValueHeaders {
+		req.Header[http.CanonicalHeaderKey(k)] = values
+	}
+
+	for k, v := range ev.Headers {
+
+		if req.Header.Get(k) == "" {
+			req.Header.Set(k, v)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	proxy_rsp := &aws_events.APIGatewayProxyResponse{
+		StatusCode:        rec.Code,
+		MultiValueHeaders: rec.Header(),
+		Body:              rec.Body.String(),
+	}
+
+	return proxy_rsp, nil
+}
+
+func serveV2Request(ctx context.Context, h http.Handler, ev *apiGatewayV2Request) (*apiGatewayV2Response, error) {
+
+	// HTTP APIs include the stage in the path unless it's the default stage
+
+	path, err := url.PathUnescape(ev.RawPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	stage := ev.RequestContext.Stage
+
+	if stage != "" && stage != "$default" {
+		path = strings.TrimPrefix(path, "/"+stage)
+	}
+
+	req, err := newProxyHTTPRequest(ctx, ev.RequestContext.HTTP.Method, path, ev.RawQueryString, ev.Body, ev.IsBase64Encoded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range ev.Headers {
+		req.Header.Set(k, v)
+	}
+
+	if len(ev.Cookies) > 0 {
+		req.Header.Set("Cookie", strings.Join(ev.Cookies, "; "))
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	headers := make(map[string]string)
+
+	for k, values := range rec.Header() {
+		headers[k] = strings.Join(values, ",")
+	}
+
+	proxy_rsp := &apiGatewayV2Response{
+		StatusCode: rec.Code,
+		Headers:    headers,
+		Body:       rec.Body.String(),
+	}
+
+	return proxy_rsp, nil
+}
+
+func newProxyHTTPRequest(ctx context.Context, method string, path string, query string, body string, is_base64 bool) (*http.Request, error) {
+
+	raw_body := []byte(body)
+
+	if is_base64 {
+
+		dec, err := base64.StdEncoding.DecodeString(body)
+
+		if err != nil {
+			return nil, err
+		}
+
+		raw_body = dec
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	u := &url.URL{
+		Path:     path,
+		RawQuery: query,
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(raw_body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return req.WithContext(ctx), nil
+}
@@ -0,0 +1,261 @@
+package ecs
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+type echoRequest struct {
+	Method string
+	Path   string
+	Query  map[string][]string
+	Header map[string][]string
+	Body   string
+}
+
+// echoHandler writes the request it was passed back as JSON, with a 418 status code and an
+// X-Echo header with two values.
+func echoHandler(t *testing.T) http.Handler {
+
+	fn := func(rsp http.ResponseWriter, req *http.Request) {
+
+		body, err := ioutil.ReadAll(req.Body)
+
+		if err != nil {
+			t.Errorf("Failed to read body, %s", err)
+		}
+
+		echo := &echoRequest{
+			Method: req.Method,
+			Path:   req.URL.Path,
+			Query:  req.URL.Query(),
+			Header: req.Header,
+			Body:   string(body),
+		}
+
+		rsp.Header().Add("X-Echo", "a")
+		rsp.Header().Add("X-Echo", "b")
+		rsp.WriteHeader(http.StatusTeapot)
+
+		json.NewEncoder(rsp).Encode(echo)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func decodeEchoRequest(t *testing.T, body string) *echoRequest {
+
+	var echo *echoRequest
+
+	err := json.Unmarshal([]byte(body), &echo)
+
+	if err != nil {
+		t.Fatalf("Failed to decode echo response, %s", err)
+	}
+
+	return echo
+}
+
+func invokeProxyHandler(t *testing.T, h http.Handler, payload string) (interface{}, error) {
+
+	fn := ProxyLambdaHandlerFunc(h)
+	return fn(context.Background(), json.RawMessage(payload))
+}
+
+func TestProxyLambdaHandlerFuncV1(t *testing.T) {
+
+	body := base64.StdEncoding.EncodeToString([]byte(`{"uris":["file:///zuber.jpg"]}`))
+
+	payload := fmt.Sprintf(`{
+		"httpMethod": "POST",
+		"path": "/jobs",
+		"queryStringParameters": {"a": "1", "b": "2"},
+		"multiValueQueryStringParameters": {"a": ["1", "3"]},
+		"headers": {"content-type": "application/json", "x-single": "one"},
+		"multiValueHeaders": {"content-type": ["application/json"], "x-multi": ["one", "two"]},
+		"body": "%s",
+		"isBase64Encoded": true
+	}`, body)
+
+	rsp, err := invokeProxyHandler(t, echoHandler(t), payload)
+
+	if err != nil {
+		t.Fatalf("Failed to handle proxy request, %s", err)
+	}
+
+	proxy_rsp, ok := rsp.(*aws_events.APIGatewayProxyResponse)
+
+	if !ok {
+		t.Fatalf("Expected an *APIGatewayProxyResponse, got %T", rsp)
+	}
+
+	if proxy_rsp.StatusCode != http.StatusTeapot {
+		t.Fatalf("Expected status %d, got %d", http.StatusTeapot, proxy_rsp.StatusCode)
+	}
+
+	if len(proxy_rsp.MultiValueHeaders["X-Echo"]) != 2 {
+		t.Fatalf("Expected two X-Echo headers, got %v", proxy_rsp.MultiValueHeaders)
+	}
+
+	echo := decodeEchoRequest(t, proxy_rsp.Body)
+
+	if echo.Method != "POST" || echo.Path != "/jobs" {
+		t.Fatalf("Unexpected request %s %s", echo.Method, echo.Path)
+	}
+
+	if echo.Body != `{"uris":["file:///zuber.jpg"]}` {
+		t.Fatalf("Expected body to be decoded, got %s", echo.Body)
+	}
+
+	// multi-value parameters take precedence over their single-value equivalents
+
+	if fmt.Sprintf("%v", echo.Query["a"]) != "[1 3]" || fmt.Sprintf("%v", echo.Query["b"]) != "[2]" {
+		t.Fatalf("Unexpected query %v", echo.Query)
+	}
+
+	if fmt.Sprintf("%v", echo.Header["X-Multi"]) != "[one two]" || fmt.Sprintf("%v", echo.Header["X-Single"]) != "[one]" {
+		t.Fatalf("Unexpected headers %v", echo.Header)
+	}
+
+	if fmt.Sprintf("%v", echo.Header["Content-Type"]) != "[application/json]" {
+		t.Fatalf("Expected one Content-Type header, got %v", echo.Header["Content-Type"])
+	}
+}
+
+func TestProxyLambdaHandlerFuncV2(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		stage string
+		path  string
+	}{
+		{"default stage", "$default", "/jobs/iiif-1234"},
+		{"named stage", "prod", "/prod/jobs/iiif-1234"},
+		{"escaped path", "$default", "/jobs/iiif%2D1234"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			payload := fmt.Sprintf(`{
+				"version": "2.0",
+				"rawPath": "%s",
+				"rawQueryString": "a=1&a=3&b=2",
+				"cookies": ["c1=v1", "c2=v2"],
+				"headers": {"x-single": "one"},
+				"body": "hello",
+				"isBase64Encoded": false,
+				"requestContext": {"stage": "%s", "http": {"method": "DELETE"}}
+			}`, tt.path, tt.stage)
+
+			rsp, err := invokeProxyHandler(t, echoHandler(t), payload)
+
+			if err != nil {
+				t.Fatalf("Failed to handle proxy request, %s", err)
+			}
+
+			proxy_rsp, ok := rsp.(*apiGatewayV2Response)
+
+			if !ok {
+				t.Fatalf("Expected an *apiGatewayV2Response, got %T", rsp)
+			}
+
+			if proxy_rsp.StatusCode != http.StatusTeapot {
+				t.Fatalf("Expected status %d, got %d", http.StatusTeapot, proxy_rsp.StatusCode)
+			}
+
+			// HTTP APIs only support single-value headers
+
+			if proxy_rsp.Headers["X-Echo"] != "a,b" {
+				t.Fatalf("Expected X-Echo header to be 'a,b', got '%s'", proxy_rsp.Headers["X-Echo"])
+			}
+
+			echo := decodeEchoRequest(t, proxy_rsp.Body)
+
+			if echo.Method != "DELETE" || echo.Path != "/jobs/iiif-1234" {
+				t.Fatalf("Unexpected request %s %s", echo.Method, echo.Path)
+			}
+
+			if echo.Body != "hello" {
+				t.Fatalf("Unexpected body %s", echo.Body)
+			}
+
+			if fmt.Sprintf("%v", echo.Query["a"]) != "[1 3]" || fmt.Sprintf("%v", echo.Query["b"]) != "[2]" {
+				t.Fatalf("Unexpected query %v", echo.Query)
+			}
+
+			if fmt.Sprintf("%v", echo.Header["X-Single"]) != "[one]" {
+				t.Fatalf("Unexpected headers %v", echo.Header)
+			}
+
+			if fmt.Sprintf("%v", echo.Header["Cookie"]) != "[c1=v1; c2=v2]" {
+				t.Fatalf("Unexpected cookies %v", echo.Header["Cookie"])
+			}
+		})
+	}
+}
+
+func TestProxyLambdaHandlerFuncErrors(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		payload string
+	}{
+		{"not json", `not json`},
+		{"v1 bad base64", `{"httpMethod": "POST", "path": "/jobs", "body": "!!!", "isBase64Encoded": true}`},
+		{"v2 bad base64", `{"version": "2.0", "rawPath": "/jobs", "body": "!!!", "isBase64Encoded": true, "requestContext": {"http": {"method": "POST"}}}`},
+		{"v2 bad path", `{"version": "2.0", "rawPath": "/jobs/%zz", "requestContext": {"http": {"method": "GET"}}}`},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := invokeProxyHandler(t, echoHandler(t), tt.payload)
+
+			if err == nil {
+				t.Fatalf("Expected %s to fail", tt.name)
+			}
+		})
+	}
+}
+
+func TestProxyLambdaHandlerFuncJobs(t *testing.T) {
+
+	svc, h := newTestJobsHandler(t)
+
+	payload := `{
+		"version": "2.0",
+		"rawPath": "/jobs",
+		"headers": {"content-type": "application/json"},
+		"body": "{\"job_id\":\"test-job\",\"uris\":[\"file:///zuber.jpg\"]}",
+		"requestContext": {"stage": "$default", "http": {"method": "POST"}}
+	}`
+
+	rsp, err := invokeProxyHandler(t, h, payload)
+
+	if err != nil {
+		t.Fatalf("Failed to handle proxy request, %s", err)
+	}
+
+	proxy_rsp := rsp.(*apiGatewayV2Response)
+
+	if proxy_rsp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, proxy_rsp.StatusCode, proxy_rsp.Body)
+	}
+
+	if proxy_rsp.Headers["Location"] != "/jobs/test-job" {
+		t.Fatalf("Unexpected Location header '%s'", proxy_rsp.Headers["Location"])
+	}
+
+	if len(svc.RunTaskInputs) != 1 {
+		t.Fatalf("Expected one task to be launched, got %d", len(svc.RunTaskInputs))
+	}
+}
@@ -0,0 +1,212 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type RetryOptions struct {
+	// The maximum number of times to try launching a task. If 0 then 1 is assumed.
+	MaxAttempts int
+	// The maximum amount of time to spend trying to launch a task. If 0 there is no limit.
+	MaxElapsed time.Duration
+	// The delay before the first retry. Subsequent delays are doubled, up to MaxDelay, and jittered.
+	// If 0 then 1 second is assumed.
+	InitialDelay time.Duration
+	// If 0 then 30 seconds is assumed.
+	MaxDelay time.Duration
+	// If true then, after a capacity error, the next attempt will use only the next subnet (and
+	// by extension availability zone) in the list of subnets passed to the task.
+	RotateSubnets bool
+}
+
+// RunTaskFailureError wraps a failure reported by ECS in the Failures property of a
+// RunTask response. See also:
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/api_failures_messages.html
+type RunTaskFailureError struct {
+	Arn    string
+	Reason string
+	Detail string
+}
+
+func (e *RunTaskFailureError) Error() string {
+
+	msg := fmt.Sprintf("Failed to run task: %s", e.Reason)
+
+	if e.Detail != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Detail)
+	}
+
+	if e.Arn != "" {
+		msg = fmt.Sprintf("%s [%s]", msg, e.Arn)
+	}
+
+	return msg
+}
+
+// IsCapacityError returns true if the failure was caused by a lack of resources or of
+// capacity in an availability zone.
+func (e *RunTaskFailureError) IsCapacityError() bool {
+
+	if strings.HasPrefix(e.Reason, "RESOURCE:") {
+		return true
+	}
+
+	return strings.Contains(strings.ToLower(e.Reason), "capacity is unavailable")
+}
+
+func (e *RunTaskFailureError) Retryable() bool {
+
+	if e.IsCapacityError() {
+		return true
+	}
+
+	return e.Reason == "AGENT"
+}
+
+// IsRetryableError returns true if err is a throttling or transient service error returned
+// by the AWS SDK or a retryable *RunTaskFailureError.
+func IsRetryableError(err error) bool {
+
+	failure, ok := err.(*RunTaskFailureError)
+
+	if ok {
+		return failure.Retryable()
+	}
+
+	return request.IsErrorThrottle(err) || request.IsErrorRetryable(err)
+}
+
+func IsCapacityError(err error) bool {
+
+	failure, ok := err.(*RunTaskFailureError)
+
+	if !ok {
+		return false
+	}
+
+	return failure.IsCapacityError()
+}
+
+// runTask calls RunTask, turning any failures in the response in to a *RunTaskFailureError,
+// and retries retryable errors according to opts.Retry.
+func (l *ProcessTaskLauncher) runTask(ctx context.Context, opts *ProcessTaskOptions, input *aws_ecs.RunTaskInput) (*aws_ecs.RunTaskOutput, error) {
+
+	retry := opts.Retry
+
+	if retry == nil {
+		retry = &RetryOptions{}
+	}
+
+	max_attempts := retry.MaxAttempts
+
+	if max_attempts < 1 {
+		max_attempts = 1
+	}
+
+	delay := retry.InitialDelay
+
+	if delay <= 0 {
+		delay = 1 * time.Second
+	}
+
+	max_delay := retry.MaxDelay
+
+	if max_delay <= 0 {
+		max_delay = 30 * time.Second
+	}
+
+	subnets := opts.Subnets
+	subnet_idx := 0
+
+	t1 := time.Now()
+
+	for attempt := 1; ; attempt++ {
+
+		rsp, err := runTaskOnce(l.service, input)
+
+		if err == nil {
+			return rsp, nil
+		}
+
+		if attempt >= max_attempts || !IsRetryableError(err) {
+			return nil, err
+		}
+
+		// full jitter, as described in
+		// https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+
+		sleep := time.Duration(rand.Int63n(int64(delay)) + 1)
+
+		if retry.MaxElapsed > 0 && time.Since(t1)+sleep > retry.MaxElapsed {
+			return nil, err
+		}
+
+		if retry.RotateSubnets && IsCapacityError(err) && len(subnets) > 1 && input.NetworkConfiguration != nil {
+
+			subnet_idx = (subnet_idx + 1) % len(subnets)
+			subnet := subnets[subnet_idx]
+
+			input.NetworkConfiguration.AwsvpcConfiguration.Subnets = []*string{
+				aws.String(subnet),
+			}
+
+			log.Printf("[WARNING] %s, retrying in subnet %s (attempt %d of %d) in %v\n", err, subnet, attempt+1, max_attempts, sleep)
+		} else {
+			log.Printf("[WARNING] %s, retrying (attempt %d of %d) in %v\n", err, attempt+1, max_attempts, sleep)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(sleep):
+			// pass
+		}
+
+		delay = delay * 2
+
+		if delay > max_delay {
+			delay = max_delay
+		}
+	}
+}
+
+func runTaskOnce(svc ECSService, input *aws_ecs.RunTaskInput) (*aws_ecs.RunTaskOutput, error) {
+
+	rsp, err := svc.RunTask(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// https://github.com/buildkite/ecs-run-task/blob/master/runner/runner.go#L148-L208
+	// this appears to be how you capture the output of an ECS task?
+	// (20190124/thisisaaronland)
+
+	if len(rsp.Tasks) == 0 {
+
+		if len(rsp.Failures) > 0 {
+
+			f := rsp.Failures[0]
+
+			err := &RunTaskFailureError{
+				Arn:    aws.StringValue(f.Arn),
+				Reason: aws.StringValue(f.Reason),
+				Detail: aws.StringValue(f.Detail),
+			}
+
+			return nil, err
+		}
+
+		return nil, &RunTaskFailureError{Reason: "run task returned no errors... but no tasks"}
+	}
+
+	return rsp, nil
+}
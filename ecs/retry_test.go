@@ -0,0 +1,94 @@
+package ecs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRunTaskRetry(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		failures     []string
+		max_attempts int
+		ok           bool
+		attempts     int
+	}{
+		{"no failures", []string{}, 3, true, 1},
+		{"capacity", []string{"RESOURCE:MEMORY"}, 3, true, 2},
+		{"capacity is unavailable", []string{"Capacity is unavailable at this time", "AGENT"}, 3, true, 3},
+		{"attempts exhausted", []string{"RESOURCE:CPU", "RESOURCE:CPU", "RESOURCE:CPU"}, 3, false, 3},
+		{"no retries", []string{"RESOURCE:CPU"}, 0, false, 1},
+		{"not retryable", []string{"MISSING"}, 3, false, 1},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			svc := NewFakeECSService()
+			svc.RunTaskFailures = tt.failures
+
+			l := NewProcessTaskLauncher(svc, nil)
+
+			opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+
+			opts.Retry = &RetryOptions{
+				MaxAttempts:  tt.max_attempts,
+				InitialDelay: time.Millisecond,
+				MaxDelay:     time.Millisecond,
+			}
+
+			rsp, err := l.LaunchProcessTask(context.Background(), opts)
+
+			if len(svc.RunTaskInputs) != tt.attempts {
+				t.Fatalf("Expected %d attempts, got %d", tt.attempts, len(svc.RunTaskInputs))
+			}
+
+			if tt.ok {
+
+				if err != nil {
+					t.Fatalf("Expected task to launch, %s", err)
+				}
+
+				if rsp == nil || rsp.TaskId == "" {
+					t.Fatalf("Expected a task ID, got %v", rsp)
+				}
+
+				return
+			}
+
+			failure, ok := err.(*RunTaskFailureError)
+
+			if !ok {
+				t.Fatalf("Expected a *RunTaskFailureError, got %v", err)
+			}
+
+			expected := tt.failures[tt.attempts-1]
+
+			if failure.Reason != expected {
+				t.Fatalf("Expected failure reason '%s', got '%s'", expected, failure.Reason)
+			}
+		})
+	}
+}
+
+func TestRunTaskFailureError(t *testing.T) {
+
+	err := &RunTaskFailureError{
+		Arn:    "arn:aws:ecs:us-east-1:000000000000:container-instance/abc",
+		Reason: "RESOURCE:MEMORY",
+		Detail: "Not enough memory",
+	}
+
+	expected := "Failed to run task: RESOURCE:MEMORY (Not enough memory) [arn:aws:ecs:us-east-1:000000000000:container-instance/abc]"
+
+	if err.Error() != expected {
+		t.Fatalf("Expected '%s', got '%s'", expected, err.Error())
+	}
+
+	if !IsCapacityError(err) || !IsRetryableError(err) {
+		t.Fatal("Expected a retryable capacity error")
+	}
+}
@@ -0,0 +1,250 @@
+package ecs
+
+import (
+	"encoding/json"
+	"errors"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"github.com/go-iiif/go-iiif-uri"
+	"log"
+	"net/url"
+	"strings"
+)
+
+// SourceMapping defines the IIIF config and instructions to use for objects in a given
+// bucket, and optionally under a given key prefix. If StripPrefix is true then Prefix is
+// removed from object keys before they are turned in to URIs, which is what you want if
+// the images.source block in the IIIF config defines the same prefix. ConfigSource and
+// InstructionsSource are Go Cloud bucket URIs and take precedence over Config and Instructions.
+type SourceMapping struct {
+	Bucket             string `json:"bucket"`
+	Prefix             string `json:"prefix,omitempty"`
+	StripPrefix        bool   `json:"strip_prefix,omitempty"`
+	Config             string `json:"config,omitempty"`
+	ConfigSource       string `json:"config_source,omitempty"`
+	ConfigName         string `json:"config_name,omitempty"`
+	Instructions       string `json:"instructions,omitempty"`
+	InstructionsSource string `json:"instructions_source,omitempty"`
+	InstructionsName   string `json:"instructions_name,omitempty"`
+}
+
+type SourceMap struct {
+	Sources []*SourceMapping `json:"sources"`
+}
+
+// NewSourceMap returns a new SourceMap from a JSON-encoded string or the path to a file
+// containing one. For example:
+//
+//	{"sources": [ {"bucket": "example", "config": "/etc/go-iiif/config.json", "instructions": "/etc/go-iiif/instructions.json"},
+//	              {"bucket": "example", "prefix": "print/", "strip_prefix": true, "config": "/etc/go-iiif/print.json", "instructions": "/etc/go-iiif/print-instructions.json"},
+//	              {"bucket": "example", "prefix": "web/", "instructions_source": "s3://example-config?region=us-east-1", "instructions_name": "web.json"} ]}
+func NewSourceMap(str_map string) (*SourceMap, error) {
+
+	body, err := readJSONDocument(str_map, "source map")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var m *SourceMap
+
+	err = json.Unmarshal(body, &m)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(m.Sources) == 0 {
+		return nil, errors.New("Source map has no sources")
+	}
+
+	for _, src := range m.Sources {
+
+		if src.Bucket == "" {
+			return nil, errors.New("Source map has a source with no bucket")
+		}
+
+		for _, str_uri := range []string{src.ConfigSource, src.InstructionsSource} {
+
+			if str_uri == "" {
+				continue
+			}
+
+			err := ValidateBucketURI(str_uri)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// Match returns the mapping for bucket with the longest prefix that key starts with.
+func (m *SourceMap) Match(bucket string, key string) (*SourceMapping, bool) {
+
+	var match *SourceMapping
+
+	for _, src := range m.Sources {
+
+		if src.Bucket != bucket || !strings.HasPrefix(key, src.Prefix) {
+			continue
+		}
+
+		if match == nil || len(src.Prefix) > len(match.Prefix) {
+			match = src
+		}
+	}
+
+	return match, match != nil
+}
+
+// apply updates s with the IIIF config and instructions settings of src.
+func (src *SourceMapping) apply(s *taskSources) {
+
+	if src.Config != "" {
+		s.setConfig(src.Config)
+	}
+
+	if src.ConfigSource != "" {
+		s.ConfigSource = src.ConfigSource
+		s.ConfigName = src.ConfigName
+	}
+
+	if src.Instructions != "" {
+		s.setInstructions(src.Instructions)
+	}
+
+	if src.InstructionsSource != "" {
+		s.setInstructionsSource(src.InstructionsSource, src.InstructionsName)
+	}
+}
+
+// S3RecordKey returns the decoded object key for an S3 event record. Keys in S3 event
+// notifications are URL-encoded (with spaces encoded as "+") so "my+photo%281%29.jpg" is
+// returned as "my photo(1).jpg".
+func S3RecordKey(r aws_events.S3EventRecord) (string, error) {
+
+	if r.S3.Object.URLDecodedKey != "" {
+		return r.S3.Object.URLDecodedKey, nil
+	}
+
+	return url.QueryUnescape(r.S3.Object.Key)
+}
+
+// S3KeyURI returns a file URI for a (decoded) object key. The key is escaped so "my photo(1).jpg"
+// becomes "file:///my%20photo%281%29.jpg" and its Origin method returns the key itself.
+func S3KeyURI(key string) (uri.URI, error) {
+
+	u := url.URL{
+		Path: key,
+	}
+
+	str_uri := uri.NewFileURIString(strings.TrimLeft(u.EscapedPath(), "/"))
+	return ParseURI(str_uri)
+}
+
+// S3EventTasks returns one copy of opts for each distinct IIIF config and instructions that
+// the objects in an S3 event map to (see SourceMap) with its URIs property set to the list
+// of image URIs for those objects. Objects that are not images, that are excluded by
+// opts.Filter or that are in a bucket with no mapping when opts.Sources is not nil, are
+// skipped.
+//
+// Records with no bucket name are assumed to have been sent by InvokeLambdaHandlerFunc and
+// their keys are treated as URI strings rather than object keys.
+func S3EventTasks(opts *ProcessTaskOptions, ev aws_events.S3Event) ([]*ProcessTaskOptions, error) {
+
+	tasks := make([]*ProcessTaskOptions, 0)
+	lookup := make(map[string]*ProcessTaskOptions)
+
+	for _, r := range ev.Records {
+
+		bucket := r.S3.Bucket.Name
+
+		sources := newTaskSources(opts)
+
+		var im uri.URI
+
+		if bucket == "" {
+
+			u, err := ParseURI(r.S3.Object.Key)
+
+			if err != nil {
+				return nil, err
+			}
+
+			im = u
+
+		} else {
+
+			key, err := S3RecordKey(r)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if opts.Filter != nil {
+
+				ok, reason := opts.Filter.Allow(bucket, key)
+
+				if !ok {
+					log.Printf("Skipping s3://%s/%s because %s\n", bucket, key, reason)
+					continue
+				}
+			}
+
+			if opts.Sources != nil {
+
+				src, ok := opts.Sources.Match(bucket, key)
+
+				if !ok {
+					log.Printf("Skipping s3://%s/%s because there is no source mapping for it\n", bucket, key)
+					continue
+				}
+
+				if src.StripPrefix {
+					key = strings.TrimPrefix(key, src.Prefix)
+				}
+
+				src.apply(sources)
+			}
+
+			u, err := S3KeyURI(key)
+
+			if err != nil {
+				return nil, err
+			}
+
+			im = u
+		}
+
+		if !isImageURI(im) {
+			log.Printf("Skipping %s because it is not an image\n", im.String())
+			continue
+		}
+
+		k := sources.key()
+
+		task_opts, ok := lookup[k]
+
+		if !ok {
+
+			o := *opts
+			sources.apply(&o)
+			o.URIs = make([]uri.URI, 0)
+
+			task_opts = &o
+
+			lookup[k] = task_opts
+			tasks = append(tasks, task_opts)
+		}
+
+		task_opts.URIs = append(task_opts.URIs, im)
+	}
+
+	return tasks, nil
+}
+
+func isImageURI(im uri.URI) bool {
+	return ValidateURI(im) == nil
+}
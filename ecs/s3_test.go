@@ -0,0 +1,265 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/go-iiif/go-iiif-uri"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestS3RecordKey(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		key      string
+		decoded  string
+		expected string
+	}{
+		{"plain", "zuber.jpg", "", "zuber.jpg"},
+		{"path", "images/2019/zuber.jpg", "", "images/2019/zuber.jpg"},
+		{"encoded", "my+photo%281%29.jpg", "", "my photo(1).jpg"},
+		{"encoded query", "zuber%3Fv%3D1.jpg", "", "zuber?v=1.jpg"},
+		{"encoded fragment", "zuber%231.jpg", "", "zuber#1.jpg"},
+		{"encoded percent", "100%25+zuber.jpg", "", "100% zuber.jpg"},
+		{"url decoded key", "my+photo%281%29.jpg", "my photo(1).jpg", "my photo(1).jpg"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			var r aws_events.S3EventRecord
+			r.S3.Object.Key = tt.key
+			r.S3.Object.URLDecodedKey = tt.decoded
+
+			key, err := S3RecordKey(r)
+
+			if err != nil {
+				t.Fatalf("Failed to decode %s, %s", tt.key, err)
+			}
+
+			if key != tt.expected {
+				t.Fatalf("Expected key '%s', got '%s'", tt.expected, key)
+			}
+		})
+	}
+}
+
+func TestS3KeyURI(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		key      string
+		expected string
+	}{
+		{"plain", "zuber.jpg", "file:///zuber.jpg"},
+		{"path", "images/2019/zuber.jpg", "file:///images/2019/zuber.jpg"},
+		{"leading slash", "/zuber.jpg", "file:///zuber.jpg"},
+		{"space and parentheses", "my photo(1).jpg", "file:///my%20photo%281%29.jpg"},
+		{"plus", "my+photo.jpg", "file:///my+photo.jpg"},
+		{"query", "zuber?v=1.jpg", "file:///zuber%3Fv=1.jpg"},
+		{"fragment", "zuber#1.jpg", "file:///zuber%231.jpg"},
+		{"percent", "100% zuber.jpg", "file:///100%25%20zuber.jpg"},
+		{"escaped percent", "zuber%281%29.jpg", "file:///zuber%25281%2529.jpg"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			u, err := S3KeyURI(tt.key)
+
+			if err != nil {
+				t.Fatalf("Failed to create URI for %s, %s", tt.key, err)
+			}
+
+			if u.String() != tt.expected {
+				t.Fatalf("Expected URI %s, got %s", tt.expected, u.String())
+			}
+
+			origin := strings.TrimLeft(tt.key, "/")
+
+			if u.Origin() != origin {
+				t.Fatalf("Expected origin '%s', got '%s'", origin, u.Origin())
+			}
+
+			// iiif-process parses the URI string again so it has to round-trip
+
+			parsed, err := uri.NewURI(u.String())
+
+			if err != nil {
+				t.Fatalf("Failed to parse %s, %s", u.String(), err)
+			}
+
+			if parsed.Origin() != origin {
+				t.Fatalf("Expected %s to have origin '%s', got '%s'", u.String(), origin, parsed.Origin())
+			}
+
+			target, err := parsed.Target(nil)
+
+			if err != nil {
+				t.Fatalf("Failed to derive target for %s, %s", u.String(), err)
+			}
+
+			if target != origin {
+				t.Fatalf("Expected %s to have target '%s', got '%s'", u.String(), origin, target)
+			}
+		})
+	}
+}
+
+func TestS3EventTasksRecordKey(t *testing.T) {
+
+	var r aws_events.S3EventRecord
+	r.S3.Bucket.Name = "example"
+	r.S3.Object.Key = "my+photo%281%29.jpg"
+
+	ev := aws_events.S3Event{
+		Records: []aws_events.S3EventRecord{r},
+	}
+
+	opts := &ProcessTaskOptions{
+		Config:       "/etc/go-iiif/config.json",
+		Instructions: "/etc/go-iiif/instructions.json",
+	}
+
+	tasks, err := S3EventTasks(opts, ev)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tasks, %s", err)
+	}
+
+	if len(tasks) != 1 || len(tasks[0].URIs) != 1 {
+		t.Fatalf("Expected one task with one URI, got %v", tasks)
+	}
+
+	expected := "file:///my%20photo%281%29.jpg"
+	str_uri := tasks[0].URIs[0].String()
+
+	if str_uri != expected {
+		t.Fatalf("Expected URI %s, got %s", expected, str_uri)
+	}
+}
+
+func TestS3KeyURIRoundTrip(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "jobs")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store, err := NewFileJobStore(filepath.Join(dir, "jobs.json"))
+
+	if err != nil {
+		t.Fatalf("Failed to create job store, %s", err)
+	}
+
+	var r aws_events.S3EventRecord
+	r.S3.Bucket.Name = "example"
+	r.S3.Object.Key = "my+photo%281%29.jpg"
+
+	ev := aws_events.S3Event{
+		Records: []aws_events.S3EventRecord{r},
+	}
+
+	opts := &ProcessTaskOptions{
+		Cluster:      "iiif",
+		Task:         "iiif-process:1",
+		Container:    "iiif-process",
+		Config:       "/etc/go-iiif/config.json",
+		Instructions: "/etc/go-iiif/instructions.json",
+		Store:        store,
+	}
+
+	tasks, err := S3EventTasks(opts, ev)
+
+	if err != nil {
+		t.Fatalf("Failed to derive tasks, %s", err)
+	}
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	ctx := context.Background()
+
+	rsp, err := l.LaunchProcessTask(ctx, tasks[0])
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	expected := "file:///my%20photo%281%29.jpg"
+
+	// task responses are read back from JSON by invoke mode and callbacks
+
+	enc_rsp, err := json.Marshal(rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to encode response, %s", err)
+	}
+
+	var dec_rsp *ProcessTaskResponse
+
+	err = json.Unmarshal(enc_rsp, &dec_rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to decode response, %s", err)
+	}
+
+	if len(dec_rsp.URIs) != 1 || dec_rsp.URIs[0].String() != expected {
+		t.Fatalf("Expected decoded response to have URI %s, got %v", expected, dec_rsp.URIs)
+	}
+
+	// and tasks are finalized using the URIs in their command
+
+	input := &aws_ecs.DescribeTasksInput{
+		Cluster: aws.String(opts.Cluster),
+		Tasks:   []*string{aws.String(rsp.TaskId)},
+	}
+
+	err = svc.WaitUntilTasksStopped(input)
+
+	if err != nil {
+		t.Fatalf("Failed to wait for task, %s", err)
+	}
+
+	task, err := DescribeTask(svc, opts.Cluster, rsp.TaskId)
+
+	if err != nil {
+		t.Fatalf("Failed to describe task, %s", err)
+	}
+
+	final_rsp, err := l.finalizeTask(ctx, opts, task, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to finalize task, %s", err)
+	}
+
+	if len(final_rsp.URIs) != 1 || final_rsp.URIs[0].String() != expected {
+		t.Fatalf("Expected finalized task to have URI %s, got %v", expected, final_rsp.URIs)
+	}
+
+	records, err := store.JobRecordsForURI(ctx, expected)
+
+	if err != nil {
+		t.Fatalf("Failed to read records, %s", err)
+	}
+
+	if len(records) != 1 {
+		t.Fatalf("Expected launching and finalizing the task to update one record, got %d", len(records))
+	}
+
+	if records[0].Status != TASK_STATUS_STOPPED {
+		t.Fatalf("Expected record to be %s, got %s", TASK_STATUS_STOPPED, records[0].Status)
+	}
+}
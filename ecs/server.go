@@ -0,0 +1,244 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MAX_JOB_REQUEST_SIZE is the largest JobRequest body, in bytes, that JobsHandler will accept.
+const MAX_JOB_REQUEST_SIZE = 1024 * 1024
+
+type jobsHandler struct {
+	launcher *ProcessTaskLauncher
+	defaults *ProcessTaskOptions
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type jobResponse struct {
+	JobId string             `json:"job_id,omitempty"`
+	Tasks []*TaskDescription `json:"tasks"`
+}
+
+// JobsHandler returns an http.Handler for submitting and tracking processing tasks:
+//
+//	POST /jobs         Launch a task for the JobRequest in the body. Returns a JobResult.
+//	GET /jobs/{id}     Returns the tasks for job {id}.
+//	DELETE /jobs/{id}  Stops the running tasks for job {id} and returns them.
+//
+// Tasks are launched using defaults, updated with the properties of each JobRequest. {id}
+// may be a job ID, a task ID or a (URL-escaped) task ARN. Tasks that weren't launched by
+// LaunchProcessTask are treated as though they don't exist.
+//
+// The handler doesn't authenticate requests so it must sit behind something that does, for
+// example an API Gateway authorizer. Set defaults.CallbackHosts to limit the hosts that job
+// requests can make it post their results to.
+func JobsHandler(l *ProcessTaskLauncher, defaults *ProcessTaskOptions) http.Handler {
+
+	h := jobsHandler{
+		launcher: l,
+		defaults: defaults,
+	}
+
+	return &h
+}
+
+func (h *jobsHandler) ServeHTTP(rsp http.ResponseWriter, req *http.Request) {
+
+	path := strings.TrimRight(req.URL.Path, "/")
+
+	if path == "/jobs" {
+
+		switch req.Method {
+		case "POST":
+			h.launchJob(rsp, req)
+		default:
+			writeJSONError(rsp, http.StatusMethodNotAllowed, "Method not allowed")
+		}
+
+		return
+	}
+
+	if !strings.HasPrefix(path, "/jobs/") {
+		writeJSONError(rsp, http.StatusNotFound, "Not found")
+		return
+	}
+
+	id := strings.TrimPrefix(path, "/jobs/")
+
+	switch req.Method {
+	case "GET":
+		h.describeJob(rsp, req, id)
+	case "DELETE":
+		h.stopJob(rsp, req, id)
+	default:
+		writeJSONError(rsp, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (h *jobsHandler) launchJob(rsp http.ResponseWriter, req *http.Request) {
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, MAX_JOB_REQUEST_SIZE+1))
+
+	if err != nil {
+		writeJSONError(rsp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if len(body) > MAX_JOB_REQUEST_SIZE {
+		writeJSONError(rsp, http.StatusRequestEntityTooLarge, "Job request is too large")
+		return
+	}
+
+	var job *JobRequest
+
+	err = json.Unmarshal(body, &job)
+
+	if err != nil || job == nil {
+		writeJSONError(rsp, http.StatusBadRequest, "Invalid job request")
+		return
+	}
+
+	opts, err := job.ProcessTaskOptions(h.defaults)
+
+	if err != nil {
+		writeJSONError(rsp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = ValidateCommandSize(opts)
+
+	if err != nil {
+		writeJSONError(rsp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// assign the job ID here so that it is included in the response
+
+	opts, err = withJobId(opts)
+
+	if err != nil {
+		writeJSONError(rsp, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	task_rsp, err := h.launcher.launchJob(req.Context(), opts)
+
+	result := &JobResult{
+		JobId: opts.JobId,
+		Task:  task_rsp,
+	}
+
+	if err != nil {
+
+		log.Printf("[ERROR] Failed to launch job '%s': %s\n", opts.JobId, err)
+
+		result.Error = err.Error()
+		writeJSON(rsp, http.StatusInternalServerError, result)
+		return
+	}
+
+	rsp.Header().Set("Location", fmt.Sprintf("/jobs/%s", url.PathEscape(opts.JobId)))
+	writeJSON(rsp, http.StatusCreated, result)
+}
+
+func (h *jobsHandler) describeJob(rsp http.ResponseWriter, req *http.Request, id string) {
+
+	ctx := req.Context()
+
+	// ListProcessTasks filters on job IDs so first assume that id is one and if
+	// there are no tasks for it try it as a task ID or ARN
+
+	if !strings.HasPrefix(id, "arn:aws:ecs:") {
+
+		tasks, err := h.launcher.ListProcessTasks(ctx, h.defaults, id)
+
+		if err != nil {
+			writeJSONError(rsp, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if len(tasks) > 0 {
+			writeJSON(rsp, http.StatusOK, &jobResponse{JobId: id, Tasks: tasks})
+			return
+		}
+	}
+
+	task, err := DescribeTask(h.launcher.service, h.defaults.Cluster, id)
+
+	if err != nil {
+		writeTaskError(rsp, err)
+		return
+	}
+
+	if !IsProcessTask(task) {
+		writeTaskError(rsp, &NotProcessTaskError{TaskId: id})
+		return
+	}
+
+	job_rsp := &jobResponse{
+		JobId: TaskJobId(task),
+		Tasks: []*TaskDescription{
+			NewTaskDescription(task, h.defaults.Container),
+		},
+	}
+
+	writeJSON(rsp, http.StatusOK, job_rsp)
+}
+
+func (h *jobsHandler) stopJob(rsp http.ResponseWriter, req *http.Request, id string) {
+
+	stopped, err := h.launcher.StopProcessTasks(req.Context(), h.defaults, id, "Stopped by iiif-process-ecs")
+
+	if err != nil {
+		writeTaskError(rsp, err)
+		return
+	}
+
+	job_rsp := &jobResponse{
+		Tasks: stopped,
+	}
+
+	if len(stopped) > 0 {
+		job_rsp.JobId = stopped[0].Task.JobId
+	}
+
+	writeJSON(rsp, http.StatusOK, job_rsp)
+}
+
+func writeTaskError(rsp http.ResponseWriter, err error) {
+
+	switch err.(type) {
+	case *TaskNotFoundError, *NotProcessTaskError:
+		writeJSONError(rsp, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSONError(rsp, http.StatusInternalServerError, err.Error())
+}
+
+func writeJSONError(rsp http.ResponseWriter, status int, msg string) {
+	writeJSON(rsp, status, &errorResponse{Error: msg})
+}
+
+func writeJSON(rsp http.ResponseWriter, status int, body interface{}) {
+
+	enc, err := json.Marshal(body)
+
+	if err != nil {
+		http.Error(rsp, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	rsp.Header().Set("Content-Type", "application/json")
+	rsp.WriteHeader(status)
+	rsp.Write(enc)
+}
@@ -0,0 +1,175 @@
+package ecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestJobsHandler(t *testing.T) (*FakeECSService, http.Handler) {
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	defaults := &ProcessTaskOptions{
+		Cluster:      "iiif",
+		Task:         "iiif-process:1",
+		Container:    "iiif-process",
+		Config:       "/etc/go-iiif/config.json",
+		Instructions: "/etc/go-iiif/instructions.json",
+	}
+
+	return svc, JobsHandler(l, defaults)
+}
+
+func doJobsRequest(t *testing.T, h http.Handler, method string, path string, body string, status int) *httptest.ResponseRecorder {
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != status {
+		t.Fatalf("Expected %s %s to return %d, got %d: %s", method, path, status, rec.Code, rec.Body.String())
+	}
+
+	return rec
+}
+
+func decodeJobResponse(t *testing.T, rec *httptest.ResponseRecorder) *jobResponse {
+
+	var job_rsp *jobResponse
+
+	err := json.Unmarshal(rec.Body.Bytes(), &job_rsp)
+
+	if err != nil {
+		t.Fatalf("Failed to decode response, %s", err)
+	}
+
+	return job_rsp
+}
+
+func TestJobsHandlerJobId(t *testing.T) {
+
+	svc, h := newTestJobsHandler(t)
+
+	rec := doJobsRequest(t, h, "POST", "/jobs", `{"job_id":"test-job","uris":["file:///zuber.jpg"]}`, http.StatusCreated)
+
+	if rec.Header().Get("Location") != "/jobs/test-job" {
+		t.Fatalf("Unexpected Location header '%s'", rec.Header().Get("Location"))
+	}
+
+	// the fake advances tasks each time they are described so stop the task before it finishes
+	// on its own
+
+	rec = doJobsRequest(t, h, "DELETE", "/jobs/test-job", "", http.StatusOK)
+	job_rsp := decodeJobResponse(t, rec)
+
+	if job_rsp.JobId != "test-job" || len(job_rsp.Tasks) != 1 {
+		t.Fatalf("Expected one stopped task for test-job, got %v", job_rsp)
+	}
+
+	rec = doJobsRequest(t, h, "GET", "/jobs/test-job", "", http.StatusOK)
+	job_rsp = decodeJobResponse(t, rec)
+
+	if job_rsp.JobId != "test-job" || len(job_rsp.Tasks) != 1 {
+		t.Fatalf("Expected one task for test-job, got %v", job_rsp)
+	}
+
+	task_id := TaskIdFromArn(job_rsp.Tasks[0].Task.TaskId)
+
+	rec = doJobsRequest(t, h, "GET", "/jobs/"+task_id, "", http.StatusOK)
+	job_rsp = decodeJobResponse(t, rec)
+
+	if job_rsp.JobId != "test-job" || len(job_rsp.Tasks) != 1 {
+		t.Fatalf("Expected task %s to belong to test-job, got %v", task_id, job_rsp)
+	}
+
+	if len(svc.RunTaskInputs) != 1 {
+		t.Fatalf("Expected one task to be launched, got %d", len(svc.RunTaskInputs))
+	}
+
+	doJobsRequest(t, h, "GET", "/jobs/missing-job", "", http.StatusNotFound)
+}
+
+func TestJobsHandlerOtherTask(t *testing.T) {
+
+	svc, h := newTestJobsHandler(t)
+
+	// a task that wasn't launched by LaunchProcessTask
+
+	input := &aws_ecs.RunTaskInput{
+		Cluster:        aws.String("iiif"),
+		TaskDefinition: aws.String("other:1"),
+	}
+
+	run_rsp, err := svc.RunTask(input)
+
+	if err != nil {
+		t.Fatalf("Failed to run task, %s", err)
+	}
+
+	task_id := TaskIdFromArn(aws.StringValue(run_rsp.Tasks[0].TaskArn))
+
+	doJobsRequest(t, h, "GET", "/jobs/"+task_id, "", http.StatusNotFound)
+	doJobsRequest(t, h, "DELETE", "/jobs/"+task_id, "", http.StatusNotFound)
+
+	task, err := DescribeTask(svc, "iiif", task_id)
+
+	if err != nil {
+		t.Fatalf("Failed to describe task, %s", err)
+	}
+
+	if aws.StringValue(task.StoppedReason) == "Stopped by iiif-process-ecs" {
+		t.Fatal("Expected task not to be stopped")
+	}
+}
+
+func TestJobsHandlerTooLarge(t *testing.T) {
+
+	svc, h := newTestJobsHandler(t)
+
+	str_uris := make([]string, 300)
+
+	for i := range str_uris {
+		str_uris[i] = fmt.Sprintf(`"file:///images/image-%04d.jpg"`, i)
+	}
+
+	body := fmt.Sprintf(`{"uris":[%s]}`, strings.Join(str_uris, ","))
+
+	rec := doJobsRequest(t, h, "POST", "/jobs", body, http.StatusBadRequest)
+
+	if !strings.Contains(rec.Body.String(), "exceeds the maximum size") {
+		t.Fatalf("Expected job to be too large, got %s", rec.Body.String())
+	}
+
+	if len(svc.RunTaskInputs) != 0 {
+		t.Fatalf("Expected no tasks to be launched, got %d", len(svc.RunTaskInputs))
+	}
+}
+
+func TestJobsHandlerCallbackHost(t *testing.T) {
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	defaults := newTestProcessTaskOptions(t)
+	defaults.CallbackHosts = []string{"example.com"}
+
+	h := JobsHandler(l, defaults)
+
+	rec := doJobsRequest(t, h, "POST", "/jobs", `{"uris":["file:///zuber.jpg"],"callback":"http://169.254.169.254/latest/meta-data"}`, http.StatusBadRequest)
+
+	if !strings.Contains(rec.Body.String(), "is not allowed") {
+		t.Fatalf("Expected callback host to be rejected, got %s", rec.Body.String())
+	}
+
+	if len(svc.RunTaskInputs) != 0 {
+		t.Fatalf("Expected no tasks to be launched, got %d", len(svc.RunTaskInputs))
+	}
+}
@@ -0,0 +1,37 @@
+package ecs
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/whosonfirst/go-whosonfirst-aws/session"
+)
+
+const (
+	TASK_STATUS_PENDING = "PENDING"
+	TASK_STATUS_RUNNING = "RUNNING"
+	TASK_STATUS_STOPPED = "STOPPED"
+)
+
+// ECSService is the subset of the AWS ECS API used to launch and wait on processing
+// tasks. It is satisfied by *aws_ecs.ECS and by FakeECSService.
+type ECSService interface {
+	RunTask(*aws_ecs.RunTaskInput) (*aws_ecs.RunTaskOutput, error)
+	DescribeTasks(*aws_ecs.DescribeTasksInput) (*aws_ecs.DescribeTasksOutput, error)
+	StopTask(*aws_ecs.StopTaskInput) (*aws_ecs.StopTaskOutput, error)
+	ListTasks(*aws_ecs.ListTasksInput) (*aws_ecs.ListTasksOutput, error)
+	WaitUntilTasksStoppedWithContext(aws.Context, *aws_ecs.DescribeTasksInput, ...request.WaiterOption) error
+	DescribeTaskDefinition(*aws_ecs.DescribeTaskDefinitionInput) (*aws_ecs.DescribeTaskDefinitionOutput, error)
+}
+
+func NewECSServiceWithDSN(dsn string) (ECSService, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := aws_ecs.New(sess)
+	return svc, nil
+}
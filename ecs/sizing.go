@@ -0,0 +1,420 @@
+package ecs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/go-iiif/go-iiif-uri"
+	"github.com/whosonfirst/go-whosonfirst-aws/session"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The number of bytes to read from the start of an image in order to determine its
+// dimensions. This needs to be large enough to skip past any EXIF or ICC profile data
+// that precedes the frame header in a JPEG file.
+const SIZING_HEADER_SIZE int64 = 256 * 1024
+
+// S3Service is the subset of the AWS S3 API used to read from (and write to) buckets. It is
+// satisfied by *s3.S3.
+type S3Service interface {
+	GetObject(*s3.GetObjectInput) (*s3.GetObjectOutput, error)
+	GetObjectWithContext(aws.Context, *s3.GetObjectInput, ...request.Option) (*s3.GetObjectOutput, error)
+	PutObjectWithContext(aws.Context, *s3.PutObjectInput, ...request.Option) (*s3.PutObjectOutput, error)
+	DeleteObjectWithContext(aws.Context, *s3.DeleteObjectInput, ...request.Option) (*s3.DeleteObjectOutput, error)
+}
+
+type SizingTier struct {
+	Name string `json:"name"`
+	// The largest image, in bytes, that this tier can process. If 0 there is no limit.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// The largest image, in pixels (width * height), that this tier can process. If 0 there is no limit.
+	MaxPixels int64 `json:"max_pixels,omitempty"`
+	// The CPU units (1024 is one vCPU) to assign to tasks in this tier.
+	CPU int64 `json:"cpu"`
+	// The memory, in MiB, to assign to tasks in this tier.
+	Memory int64 `json:"memory"`
+}
+
+type SizingSource struct {
+	Bucket string `json:"bucket"`
+	Prefix string `json:"prefix,omitempty"`
+	Region string `json:"region,omitempty"`
+}
+
+// SizingPolicy picks the CPU and memory to assign to a task from the size of the source
+// images it will process. Tiers are checked in order and the first tier that an image
+// fits in is used. Images that don't fit in any tier are assigned the last one.
+type SizingPolicy struct {
+	Source  *SizingSource `json:"source"`
+	Tiers   []*SizingTier `json:"tiers"`
+	service S3Service
+}
+
+type ImageSize struct {
+	Bytes  int64
+	Width  int64
+	Height int64
+}
+
+func (sz *ImageSize) Pixels() int64 {
+	return sz.Width * sz.Height
+}
+
+// NewSizingPolicy returns a new SizingPolicy from a JSON-encoded string or the path to a
+// file containing one. For example:
+//
+//	{"source": {"bucket": "example", "prefix": "images", "region": "us-east-1"},
+//	 "tiers": [ {"name": "small", "max_bytes": 10485760, "max_pixels": 25000000, "cpu": 512, "memory": 2048},
+//	            {"name": "large", "cpu": 4096, "memory": 16384} ]}
+func NewSizingPolicy(svc S3Service, str_policy string) (*SizingPolicy, error) {
+
+	body, err := readJSONDocument(str_policy, "sizing policy")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var p *SizingPolicy
+
+	err = json.Unmarshal(body, &p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.Source == nil || p.Source.Bucket == "" {
+		return nil, errors.New("Sizing policy is missing a source bucket")
+	}
+
+	if len(p.Tiers) == 0 {
+		return nil, errors.New("Sizing policy has no tiers")
+	}
+
+	for _, t := range p.Tiers {
+
+		if t.CPU < 0 || t.Memory < 0 {
+			msg := fmt.Sprintf("Sizing tier '%s' has an invalid CPU or memory value", t.Name)
+			return nil, errors.New(msg)
+		}
+	}
+
+	p.service = svc
+	return p, nil
+}
+
+// NewSizingPolicyWithDSN returns a new SizingPolicy (see NewSizingPolicy) that reads images
+// using the credentials in a (go-whosonfirst-aws) DSN string. If the policy defines a source
+// region it will be used instead of the region in the DSN string.
+func NewSizingPolicyWithDSN(dsn string, str_policy string) (*SizingPolicy, error) {
+
+	p, err := NewSizingPolicy(nil, str_policy)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc, err := NewS3ServiceWithDSN(dsn, p.Source.Region)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.service = svc
+	return p, nil
+}
+
+// NewS3ServiceWithDSN returns an S3Service using the credentials in a (go-whosonfirst-aws) DSN
+// string. If region is not empty it is used instead of the region in the DSN string.
+func NewS3ServiceWithDSN(dsn string, region string) (S3Service, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := aws.NewConfig()
+
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+
+	return s3.New(sess, cfg), nil
+}
+
+func (p *SizingPolicy) Tier(sz *ImageSize) *SizingTier {
+
+	for _, t := range p.Tiers {
+
+		if t.MaxBytes > 0 && sz.Bytes > t.MaxBytes {
+			continue
+		}
+
+		// if we couldn't work out the dimensions of an image then size it
+		// by its bytes alone
+
+		if t.MaxPixels > 0 && sz.Pixels() > t.MaxPixels {
+			continue
+		}
+
+		return t
+	}
+
+	return p.Tiers[len(p.Tiers)-1]
+}
+
+// GroupURIs groups uris by the tier they fall in to, in the order that tiers are defined.
+// URIs whose size can't be determined are grouped under a nil tier, which is always last.
+func (p *SizingPolicy) GroupURIs(uris []uri.URI) ([]*SizingTier, [][]uri.URI) {
+
+	tiers := make([]*SizingTier, 0)
+	groups := make([][]uri.URI, 0)
+
+	lookup := make(map[*SizingTier][]uri.URI)
+	unsized := make([]uri.URI, 0)
+
+	for _, u := range uris {
+
+		t, err := p.TierForURI(u)
+
+		if err != nil {
+			log.Printf("[WARNING] Unable to determine the size of %s: %s\n", RedactURI(u.String()), err)
+			unsized = append(unsized, u)
+			continue
+		}
+
+		lookup[t] = append(lookup[t], u)
+	}
+
+	for _, t := range p.Tiers {
+
+		group, ok := lookup[t]
+
+		if ok {
+			tiers = append(tiers, t)
+			groups = append(groups, group)
+		}
+	}
+
+	if len(unsized) > 0 {
+		tiers = append(tiers, nil)
+		groups = append(groups, unsized)
+	}
+
+	return tiers, groups
+}
+
+// TierForURIs returns the largest tier (by memory and then CPU) that any of uris fall in
+// to. Images whose size can't be determined are skipped. If no image could be sized then
+// nil is returned.
+func (p *SizingPolicy) TierForURIs(uris []uri.URI) *SizingTier {
+
+	var tier *SizingTier
+
+	for _, u := range uris {
+
+		t, err := p.TierForURI(u)
+
+		if err != nil {
+			log.Printf("[WARNING] Unable to determine the size of %s: %s\n", RedactURI(u.String()), err)
+			continue
+		}
+
+		if tier == nil || t.Memory > tier.Memory || (t.Memory == tier.Memory && t.CPU > tier.CPU) {
+			tier = t
+		}
+	}
+
+	return tier
+}
+
+func (p *SizingPolicy) TierForURI(u uri.URI) (*SizingTier, error) {
+
+	sz, err := p.ImageSize(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return p.Tier(sz), nil
+}
+
+// ImageSize reads the first SIZING_HEADER_SIZE bytes of the source image for u in order
+// to determine its size in bytes (from the Content-Range header) and its dimensions.
+func (p *SizingPolicy) ImageSize(u uri.URI) (*ImageSize, error) {
+
+	key := u.Origin()
+
+	if p.Source.Prefix != "" {
+		key = path.Join(p.Source.Prefix, key)
+	}
+
+	header, total, err := p.readRange(key, 0, SIZING_HEADER_SIZE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sz := &ImageSize{
+		Bytes: total,
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(header))
+
+	if err == nil {
+		sz.Width = int64(cfg.Width)
+		sz.Height = int64(cfg.Height)
+		return sz, nil
+	}
+
+	w, h, err := p.tiffDimensions(key, header)
+
+	if err == nil {
+		sz.Width = w
+		sz.Height = h
+	}
+
+	return sz, nil
+}
+
+func (p *SizingPolicy) readRange(key string, offset int64, length int64) ([]byte, int64, error) {
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(p.Source.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+
+	rsp, err := p.service.GetObject(input)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	defer rsp.Body.Close()
+
+	body, err := ioutil.ReadAll(rsp.Body)
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Content-Range: bytes 0-262143/12345678
+
+	total := aws.Int64Value(rsp.ContentLength)
+	content_range := aws.StringValue(rsp.ContentRange)
+
+	idx := strings.LastIndex(content_range, "/")
+
+	if idx != -1 {
+
+		i, err := strconv.ParseInt(content_range[idx+1:], 10, 64)
+
+		if err == nil {
+			total = i
+		}
+	}
+
+	return body, total, nil
+}
+
+// tiffDimensions reads the ImageWidth and ImageLength tags from the first IFD of a TIFF
+// file. In large TIFF files the IFD is often written after the image data so if it's not
+// in header we go back and fetch it.
+func (p *SizingPolicy) tiffDimensions(key string, header []byte) (int64, int64, error) {
+
+	if len(header) < 8 {
+		return 0, 0, errors.New("Not a TIFF file")
+	}
+
+	var order binary.ByteOrder
+
+	switch string(header[0:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 0, 0, errors.New("Not a TIFF file")
+	}
+
+	offset := int64(order.Uint32(header[4:8]))
+
+	var ifd []byte
+
+	if offset+2 <= int64(len(header)) {
+		ifd = header[offset:]
+	} else {
+
+		// 2 bytes for the entry count and 12 bytes for each entry; the
+		// width and height are almost always in the first few entries
+
+		b, _, err := p.readRange(key, offset, 2+(12*64))
+
+		if err != nil {
+			return 0, 0, err
+		}
+
+		ifd = b
+	}
+
+	if len(ifd) < 2 {
+		return 0, 0, errors.New("Invalid TIFF IFD")
+	}
+
+	count := int(order.Uint16(ifd[0:2]))
+
+	var width int64
+	var height int64
+
+	for i := 0; i < count; i++ {
+
+		start := 2 + (i * 12)
+
+		if start+12 > len(ifd) {
+			break
+		}
+
+		entry := ifd[start : start+12]
+
+		tag := order.Uint16(entry[0:2])
+		typ := order.Uint16(entry[2:4])
+
+		var value int64
+
+		switch typ {
+		case 3: // SHORT
+			value = int64(order.Uint16(entry[8:10]))
+		case 4: // LONG
+			value = int64(order.Uint32(entry[8:12]))
+		default:
+			continue
+		}
+
+		switch tag {
+		case 256:
+			width = value
+		case 257:
+			height = value
+		}
+
+		if width > 0 && height > 0 {
+			return width, height, nil
+		}
+	}
+
+	return 0, 0, errors.New("Unable to find TIFF dimensions")
+}
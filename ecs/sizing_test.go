@@ -0,0 +1,279 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/go-iiif/go-iiif-uri"
+	"image"
+	"image/jpeg"
+	"testing"
+)
+
+const testSizingPolicy string = `{"source": {"bucket": "example", "prefix": "images"},
+ "tiers": [ {"name": "small", "max_bytes": 1048576, "max_pixels": 1000000, "cpu": 512, "memory": 2048},
+            {"name": "medium", "max_bytes": 10485760, "max_pixels": 25000000, "cpu": 1024, "memory": 4096},
+            {"name": "large", "cpu": 4096, "memory": 16384} ]}`
+
+// newTestJPEG returns a JPEG image with the given dimensions.
+func newTestJPEG(t *testing.T, width int, height int) []byte {
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil)
+
+	if err != nil {
+		t.Fatalf("Failed to encode JPEG, %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// newTestTIFF returns a (minimal) TIFF file whose first IFD, containing only the ImageWidth
+// and ImageLength tags, follows padding bytes of image data.
+func newTestTIFF(order binary.ByteOrder, width uint32, height uint32, padding int) []byte {
+
+	var buf bytes.Buffer
+
+	if order == binary.LittleEndian {
+		buf.WriteString("II*\x00")
+	} else {
+		buf.WriteString("MM\x00*")
+	}
+
+	binary.Write(&buf, order, uint32(8+padding))
+	buf.Write(make([]byte, padding))
+
+	binary.Write(&buf, order, uint16(2))
+
+	// ImageWidth as a SHORT (if it fits) and ImageLength as a LONG
+
+	binary.Write(&buf, order, uint16(256))
+	binary.Write(&buf, order, uint16(3))
+	binary.Write(&buf, order, uint32(1))
+	binary.Write(&buf, order, uint16(width))
+	binary.Write(&buf, order, uint16(0))
+
+	binary.Write(&buf, order, uint16(257))
+	binary.Write(&buf, order, uint16(4))
+	binary.Write(&buf, order, uint32(1))
+	binary.Write(&buf, order, height)
+
+	binary.Write(&buf, order, uint32(0))
+
+	return buf.Bytes()
+}
+
+func newTestSizingPolicy(t *testing.T) (*SizingPolicy, *fakeS3Service) {
+
+	svc := newFakeS3Service()
+
+	p, err := NewSizingPolicy(svc, testSizingPolicy)
+
+	if err != nil {
+		t.Fatalf("Failed to create sizing policy, %s", err)
+	}
+
+	return p, svc
+}
+
+func TestNewSizingPolicy(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		policy string
+		valid  bool
+	}{
+		{"valid", testSizingPolicy, true},
+		{"missing source", `{"tiers": [{"name": "small", "cpu": 512, "memory": 2048}]}`, false},
+		{"missing bucket", `{"source": {"prefix": "images"}, "tiers": [{"name": "small", "cpu": 512, "memory": 2048}]}`, false},
+		{"no tiers", `{"source": {"bucket": "example"}, "tiers": []}`, false},
+		{"negative memory", `{"source": {"bucket": "example"}, "tiers": [{"name": "small", "cpu": 512, "memory": -1}]}`, false},
+		{"not an object", `[]`, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			_, err := NewSizingPolicy(newFakeS3Service(), tt.policy)
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected policy to be valid, %s", err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatal("Expected policy to be invalid")
+			}
+		})
+	}
+}
+
+func TestSizingPolicyTierForURI(t *testing.T) {
+
+	p, svc := newTestSizingPolicy(t)
+
+	small_jpeg := newTestJPEG(t, 640, 480)
+	medium_jpeg := newTestJPEG(t, 2000, 1500)
+
+	// a JPEG whose header has been cut off before the frame header (the SOI marker and
+	// the start of a JFIF segment) padded out to 2MB
+
+	truncated_jpeg := append([]byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F'}, make([]byte, 2*1024*1024)...)
+
+	// a TIFF whose IFD is past the first SIZING_HEADER_SIZE bytes so it has to be read separately
+
+	large_tiff := newTestTIFF(binary.BigEndian, 6000, 5000, int(SIZING_HEADER_SIZE)+1024)
+
+	tests := []struct {
+		name     string
+		key      string
+		body     []byte
+		width    int64
+		height   int64
+		expected string
+	}{
+		{"small jpeg", "small.jpg", small_jpeg, 640, 480, "small"},
+		{"medium jpeg", "medium.jpg", medium_jpeg, 2000, 1500, "medium"},
+		{"small tiff", "small.tif", newTestTIFF(binary.LittleEndian, 800, 600, 16), 800, 600, "small"},
+		{"medium tiff", "medium.tif", newTestTIFF(binary.LittleEndian, 4000, 3000, 16), 4000, 3000, "medium"},
+		{"large tiff", "large.tif", large_tiff, 6000, 5000, "large"},
+		// dimensions can't be determined so the image is sized by its bytes alone
+		{"truncated jpeg", "truncated.jpg", truncated_jpeg, 0, 0, "medium"},
+		{"malformed tiff", "malformed.tif", []byte("II*\x00\xff\xff\xff\xff"), 0, 0, "small"},
+		{"not an image", "not-an-image.jpg", []byte("hello world"), 0, 0, "small"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			svc.Put("images/"+tt.key, tt.body)
+
+			u, err := ParseURI("file:///" + tt.key)
+
+			if err != nil {
+				t.Fatalf("Failed to parse URI, %s", err)
+			}
+
+			sz, err := p.ImageSize(u)
+
+			if err != nil {
+				t.Fatalf("Failed to determine size, %s", err)
+			}
+
+			if sz.Bytes != int64(len(tt.body)) {
+				t.Fatalf("Expected %d bytes, got %d", len(tt.body), sz.Bytes)
+			}
+
+			if sz.Width != tt.width || sz.Height != tt.height {
+				t.Fatalf("Expected %dx%d, got %dx%d", tt.width, tt.height, sz.Width, sz.Height)
+			}
+
+			tier, err := p.TierForURI(u)
+
+			if err != nil {
+				t.Fatalf("Failed to determine tier, %s", err)
+			}
+
+			if tier.Name != tt.expected {
+				t.Fatalf("Expected tier %s, got %s", tt.expected, tier.Name)
+			}
+		})
+	}
+}
+
+func TestSizingPolicyTierForURIs(t *testing.T) {
+
+	p, svc := newTestSizingPolicy(t)
+
+	svc.Put("images/small.jpg", newTestJPEG(t, 640, 480))
+	svc.Put("images/medium.jpg", newTestJPEG(t, 2000, 1500))
+
+	parse := func(str_uris ...string) []uri.URI {
+
+		uris := make([]uri.URI, len(str_uris))
+
+		for i, str_uri := range str_uris {
+
+			u, err := ParseURI(str_uri)
+
+			if err != nil {
+				t.Fatalf("Failed to parse %s, %s", str_uri, err)
+			}
+
+			uris[i] = u
+		}
+
+		return uris
+	}
+
+	tests := []struct {
+		name     string
+		uris     []uri.URI
+		expected string
+	}{
+		{"small", parse("file:///small.jpg"), "small"},
+		{"largest", parse("file:///small.jpg", "file:///medium.jpg"), "medium"},
+		{"missing skipped", parse("file:///missing.jpg", "file:///small.jpg"), "small"},
+		// no image could be sized so the task's defaults are used
+		{"missing", parse("file:///missing.jpg"), ""},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			tier := p.TierForURIs(tt.uris)
+
+			if tt.expected == "" {
+
+				if tier != nil {
+					t.Fatalf("Expected no tier, got %s", tier.Name)
+				}
+
+				return
+			}
+
+			if tier == nil || tier.Name != tt.expected {
+				t.Fatalf("Expected tier %s, got %v", tt.expected, tier)
+			}
+		})
+	}
+
+	tiers, groups := p.GroupURIs(parse("file:///medium.jpg", "file:///missing.jpg", "file:///small.jpg"))
+
+	if len(tiers) != 3 || tiers[0].Name != "small" || tiers[1].Name != "medium" || tiers[2] != nil {
+		t.Fatalf("Unexpected tiers %v", tiers)
+	}
+
+	if len(groups[2]) != 1 || groups[2][0].String() != "file:///missing.jpg" {
+		t.Fatalf("Expected missing image to be grouped last, got %v", groups[2])
+	}
+}
+
+func TestLaunchProcessTaskSizingPolicy(t *testing.T) {
+
+	p, s3_svc := newTestSizingPolicy(t)
+
+	s3_svc.Put("images/medium.jpg", newTestJPEG(t, 2000, 1500))
+
+	opts := newTestProcessTaskOptions(t, "file:///medium.jpg")
+	opts.SizingPolicy = p
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	_, err := l.LaunchProcessTask(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	overrides := svc.RunTaskInputs[0].Overrides
+
+	if aws.StringValue(overrides.Cpu) != "1024" || aws.StringValue(overrides.Memory) != "4096" {
+		t.Fatalf("Expected medium tier CPU and memory, got %s and %s", aws.StringValue(overrides.Cpu), aws.StringValue(overrides.Memory))
+	}
+}
@@ -0,0 +1,151 @@
+package ecs
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+const DEFAULT_CONFIG_NAME string = "config.json"
+
+const DEFAULT_INSTRUCTIONS_NAME string = "instructions.json"
+
+// ValidateBucketURI ensures that str_uri is a Go Cloud bucket URI (for example "s3://bucket?region=us-east-1"
+// or "file:///etc/go-iiif") as expected by the -config-source and -instructions-source flags of iiif-process.
+func ValidateBucketURI(str_uri string) error {
+
+	u, err := url.Parse(str_uri)
+
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme == "" {
+		msg := fmt.Sprintf("Invalid bucket URI '%s', missing scheme", str_uri)
+		return errors.New(msg)
+	}
+
+	if u.Host == "" && u.Path == "" {
+		msg := fmt.Sprintf("Invalid bucket URI '%s', missing bucket", str_uri)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// sourceArgs returns the iiif-process arguments for a config or instructions file. If source is
+// set the -{prefix}-source and -{prefix}-name flags are used, otherwise the (deprecated) -{prefix}
+// flag is used with path.
+func sourceArgs(prefix string, source string, name string, path string, default_name string) ([]string, error) {
+
+	if source == "" {
+
+		if path == "" {
+			msg := fmt.Sprintf("Missing %s path or source", prefix)
+			return nil, errors.New(msg)
+		}
+
+		args := []string{
+			fmt.Sprintf("-%s", prefix),
+			path,
+		}
+
+		return args, nil
+	}
+
+	err := ValidateBucketURI(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if name == "" {
+		name = default_name
+	}
+
+	args := []string{
+		fmt.Sprintf("-%s-source", prefix),
+		source,
+		fmt.Sprintf("-%s-name", prefix),
+		name,
+	}
+
+	return args, nil
+}
+
+// configArgs returns the iiif-process arguments for the IIIF config in opts.
+func configArgs(opts *ProcessTaskOptions) ([]string, error) {
+	return sourceArgs("config", opts.ConfigSource, opts.ConfigName, opts.Config, DEFAULT_CONFIG_NAME)
+}
+
+// instructionsArgs returns the iiif-process arguments for the IIIF instructions in opts.
+func instructionsArgs(opts *ProcessTaskOptions) ([]string, error) {
+	return sourceArgs("instructions", opts.InstructionsSource, opts.InstructionsName, opts.Instructions, DEFAULT_INSTRUCTIONS_NAME)
+}
+
+// taskSources are the IIIF config and instructions settings of a ProcessTaskOptions. URIs
+// are grouped in to tasks by their taskSources.
+type taskSources struct {
+	Config               string
+	ConfigSource         string
+	ConfigName           string
+	Instructions         string
+	InstructionsSource   string
+	InstructionsName     string
+	InstructionsDocument []byte
+}
+
+func newTaskSources(opts *ProcessTaskOptions) *taskSources {
+
+	s := taskSources{
+		Config:               opts.Config,
+		ConfigSource:         opts.ConfigSource,
+		ConfigName:           opts.ConfigName,
+		Instructions:         opts.Instructions,
+		InstructionsSource:   opts.InstructionsSource,
+		InstructionsName:     opts.InstructionsName,
+		InstructionsDocument: opts.InstructionsDocument,
+	}
+
+	return &s
+}
+
+// setConfig sets the config path, clearing any config source since it would otherwise take
+// precedence.
+func (s *taskSources) setConfig(path string) {
+	s.Config = path
+	s.ConfigSource = ""
+	s.ConfigName = ""
+}
+
+// setInstructions sets the instructions path, clearing any instructions source or document
+// since they would otherwise take precedence.
+func (s *taskSources) setInstructions(path string) {
+	s.Instructions = path
+	s.InstructionsSource = ""
+	s.InstructionsName = ""
+	s.InstructionsDocument = nil
+}
+
+// setInstructionsSource sets the instructions source, clearing any instructions document since
+// it would otherwise take precedence.
+func (s *taskSources) setInstructionsSource(source string, name string) {
+	s.InstructionsSource = source
+	s.InstructionsName = name
+	s.InstructionsDocument = nil
+}
+
+func (s *taskSources) key() string {
+	return fmt.Sprintf("%s#%s#%s#%s#%s#%s#%x", s.Config, s.ConfigSource, s.ConfigName, s.Instructions, s.InstructionsSource, s.InstructionsName, sha256.Sum256(s.InstructionsDocument))
+}
+
+func (s *taskSources) apply(opts *ProcessTaskOptions) {
+	opts.Config = s.Config
+	opts.ConfigSource = s.ConfigSource
+	opts.ConfigName = s.ConfigName
+	opts.Instructions = s.Instructions
+	opts.InstructionsSource = s.InstructionsSource
+	opts.InstructionsName = s.InstructionsName
+	opts.InstructionsDocument = s.InstructionsDocument
+}
@@ -0,0 +1,258 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"github.com/go-iiif/go-iiif-uri"
+	"log"
+)
+
+// SQSEventResponse is the response for a Lambda function triggered by SQS with the
+// ReportBatchItemFailures function response type enabled. Only the messages listed in
+// BatchItemFailures are returned to the queue.
+// https://docs.aws.amazon.com/lambda/latest/dg/with-sqs.html#services-sqs-batchfailurereporting
+type SQSEventResponse struct {
+	BatchItemFailures []SQSBatchItemFailure `json:"batchItemFailures"`
+}
+
+type SQSBatchItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// sqsMessage is the part of an SQS message, received either by a Lambda function or by
+// a Worker, needed to launch tasks for it.
+type sqsMessage struct {
+	Id   string
+	Body string
+}
+
+// SQSMessageTasks returns the list of tasks (see S3EventTasks) for the event in the body of
+// an SQS message, which may be a JobRequest or anything understood by NormalizeEvent. S3
+// test events (which are sent when notifications are first configured) yield an empty list.
+func SQSMessageTasks(opts *ProcessTaskOptions, msg aws_events.SQSMessage) ([]*ProcessTaskOptions, error) {
+	return sqsBodyTasks(opts, msg.Body)
+}
+
+// sqsBodyTasks returns the tasks for a message body. URIs are validated here, rather than when
+// their tasks are launched, so that a bad message doesn't fail the other messages it is grouped with.
+func sqsBodyTasks(opts *ProcessTaskOptions, body string) ([]*ProcessTaskOptions, error) {
+
+	tasks, err := parseSQSBody(opts, body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, task_opts := range tasks {
+
+		for _, u := range task_opts.URIs {
+
+			err := ValidateURI(u)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return tasks, nil
+}
+
+func parseSQSBody(opts *ProcessTaskOptions, body string) ([]*ProcessTaskOptions, error) {
+
+	if IsJobRequest([]byte(body)) {
+
+		var job *JobRequest
+
+		err := json.Unmarshal([]byte(body), &job)
+
+		if err != nil {
+			return nil, err
+		}
+
+		job_opts, err := job.ProcessTaskOptions(opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// give every job request its own job ID so that it isn't grouped with other
+		// job requests, whose settings may differ, in the same batch
+
+		job_opts, err = withJobId(job_opts)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return []*ProcessTaskOptions{job_opts}, nil
+	}
+
+	s3_ev, err := NormalizeEvent([]byte(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return S3EventTasks(opts, s3_ev)
+}
+
+// SQSLambdaHandlerFunc returns a Lambda handler for batches of SQS messages wrapping S3 event
+// notifications (or job requests). All the URIs in a batch that share the same IIIF config and
+// instructions are launched together (see LaunchProcessTaskBatch) and any message that could
+// not be parsed, or whose URIs failed to launch, is reported as a batch item failure so that
+// it will be redelivered.
+func SQSLambdaHandlerFunc(opts *ProcessTaskOptions, batch_opts *BatchOptions) func(ctx context.Context, ev aws_events.SQSEvent) (*SQSEventResponse, error) {
+
+	handler := func(ctx context.Context, ev aws_events.SQSEvent) (*SQSEventResponse, error) {
+
+		l, err := NewProcessTaskLauncherWithDSN(opts.DSN)
+
+		if err != nil {
+			return nil, err
+		}
+
+		msgs := make([]*sqsMessage, len(ev.Records))
+
+		for i, r := range ev.Records {
+			msgs[i] = &sqsMessage{
+				Id:   r.MessageId,
+				Body: r.Body,
+			}
+		}
+
+		failed := l.launchSQSMessages(ctx, opts, batch_opts, msgs)
+
+		sqs_rsp := &SQSEventResponse{
+			BatchItemFailures: make([]SQSBatchItemFailure, 0),
+		}
+
+		for _, msg := range msgs {
+
+			if !failed[msg.Id] {
+				continue
+			}
+
+			f := SQSBatchItemFailure{
+				ItemIdentifier: msg.Id,
+			}
+
+			sqs_rsp.BatchItemFailures = append(sqs_rsp.BatchItemFailures, f)
+		}
+
+		return sqs_rsp, nil
+	}
+
+	return handler
+}
+
+// sqsTaskKey returns the key that the URIs for opts are grouped by in launchSQSMessages, which
+// is to say everything that a job request can set on a per-job basis.
+func sqsTaskKey(opts *ProcessTaskOptions) string {
+	return fmt.Sprintf("%s#%s#%t#%s#%s", newTaskSources(opts).key(), opts.JobId, opts.Report, opts.ReportName, opts.Callback)
+}
+
+// launchSQSMessages launches tasks for all the URIs in msgs, grouped by IIIF config,
+// instructions, job ID and the other settings in job requests, and returns the set of message IDs that could not be parsed or
+// whose URIs failed to launch (or, if opts.Wait is true, failed to process).
+func (l *ProcessTaskLauncher) launchSQSMessages(ctx context.Context, opts *ProcessTaskOptions, batch_opts *BatchOptions, msgs []*sqsMessage) map[string]bool {
+
+	failed := make(map[string]bool)
+
+	// group URIs by IIIF config and instructions across all the messages and keep
+	// track of which messages each URI came from. Job requests are never grouped with
+	// other messages since each one is assigned its own job ID (see parseSQSBody)
+
+	tasks := make([]*ProcessTaskOptions, 0)
+	tasks_lookup := make(map[string]*ProcessTaskOptions)
+
+	msg_lookup := make(map[string][]string)
+
+	for _, msg := range msgs {
+
+		msg_tasks, err := sqsBodyTasks(opts, msg.Body)
+
+		if err != nil {
+			log.Printf("[WARNING] Failed to parse message %s: %s\n", msg.Id, err)
+			failed[msg.Id] = true
+			continue
+		}
+
+		for _, msg_opts := range msg_tasks {
+
+			k := sqsTaskKey(msg_opts)
+
+			task_opts, ok := tasks_lookup[k]
+
+			if !ok {
+				o := *msg_opts
+				o.URIs = make([]uri.URI, 0)
+				task_opts = &o
+				tasks_lookup[k] = task_opts
+				tasks = append(tasks, task_opts)
+			}
+
+			for _, u := range msg_opts.URIs {
+
+				str_uri := fmt.Sprintf("%s#%s", k, u.String())
+
+				_, seen := msg_lookup[str_uri]
+
+				if !seen {
+					task_opts.URIs = append(task_opts.URIs, u)
+				}
+
+				msg_lookup[str_uri] = append(msg_lookup[str_uri], msg.Id)
+			}
+		}
+	}
+
+	for _, task_opts := range tasks {
+
+		k := sqsTaskKey(task_opts)
+
+		rsp, err := l.LaunchProcessTaskBatch(ctx, task_opts, batch_opts)
+
+		if err != nil {
+			log.Printf("[WARNING] Failed to launch tasks: %s\n", err)
+		}
+
+		postBatchJobResults(ctx, task_opts, rsp, err)
+
+		for _, u := range task_opts.URIs {
+
+			str_uri := u.String()
+			ok := false
+
+			if rsp != nil {
+				_, launched := rsp.TaskIds[str_uri]
+				_, errored := rsp.Errors[str_uri]
+				ok = launched && !errored
+			}
+
+			if ok {
+				continue
+			}
+
+			for _, msg_id := range msg_lookup[fmt.Sprintf("%s#%s", k, str_uri)] {
+				failed[msg_id] = true
+			}
+		}
+
+		if rsp != nil {
+
+			enc_rsp, err := json.Marshal(rsp.Redacted())
+
+			if err != nil {
+				log.Printf("[WARNING] Failed to encode response: %s\n", err)
+				continue
+			}
+
+			log.Println(string(enc_rsp))
+		}
+	}
+
+	return failed
+}
@@ -0,0 +1,138 @@
+package ecs
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"strings"
+	"testing"
+)
+
+func TestLaunchSQSMessages(t *testing.T) {
+
+	msgs := []*sqsMessage{
+		&sqsMessage{Id: "s3", Body: testS3Event},
+		&sqsMessage{Id: "s3-other", Body: `{"Records":[{"eventSource":"aws:s3","s3":{"bucket":{"name":"example"},"object":{"key":"avocado.png"}}}]}`},
+		&sqsMessage{Id: "job", Body: `{"uris":["file:///zuber.jpg"]}`},
+		&sqsMessage{Id: "test", Body: `{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"example"}`},
+		&sqsMessage{Id: "invalid", Body: `{"uris":`},
+		&sqsMessage{Id: "unrecognized", Body: `{"hello":"world"}`},
+		&sqsMessage{Id: "not-an-image", Body: `{"uris":["file:///notes.txt"]}`},
+	}
+
+	tests := []struct {
+		name     string
+		failures []string
+		failed   []string
+	}{
+		{"launched", []string{}, []string{"invalid", "unrecognized", "not-an-image"}},
+		{"launch failed", []string{"MISSING", "MISSING"}, []string{"s3", "s3-other", "job", "invalid", "unrecognized", "not-an-image"}},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			svc := NewFakeECSService()
+			svc.RunTaskFailures = tt.failures
+
+			l := NewProcessTaskLauncher(svc, nil)
+
+			opts := newTestProcessTaskOptions(t)
+
+			failed := l.launchSQSMessages(context.Background(), opts, &BatchOptions{}, msgs)
+
+			if len(failed) != len(tt.failed) {
+				t.Fatalf("Expected %d failed messages, got %v", len(tt.failed), failed)
+			}
+
+			for _, id := range tt.failed {
+
+				if !failed[id] {
+					t.Fatalf("Expected message %s to fail, got %v", id, failed)
+				}
+			}
+
+			// the URIs from the S3 events are launched together but the job request
+			// is launched on its own
+
+			if len(svc.RunTaskInputs) != 2 {
+				t.Fatalf("Expected two calls to RunTask, got %d", len(svc.RunTaskInputs))
+			}
+		})
+	}
+}
+
+func TestLaunchSQSMessagesJobRequestSettings(t *testing.T) {
+
+	msgs := []*sqsMessage{
+		&sqsMessage{Id: "a", Body: `{"uris":["file:///a.jpg"]}`},
+		&sqsMessage{Id: "b", Body: `{"uris":["file:///b.jpg"],"report":true,"report_name":"x.json"}`},
+		&sqsMessage{Id: "c", Body: `{"uris":["file:///c.jpg"],"callback":"http://localhost/callback"}`},
+	}
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	opts := newTestProcessTaskOptions(t)
+
+	failed := l.launchSQSMessages(context.Background(), opts, &BatchOptions{}, msgs[:2])
+
+	if len(failed) != 0 {
+		t.Fatalf("Expected no failed messages, got %v", failed)
+	}
+
+	if len(svc.RunTaskInputs) != 2 {
+		t.Fatalf("Expected one call to RunTask for each job request, got %d", len(svc.RunTaskInputs))
+	}
+
+	job_ids := make(map[string]bool)
+
+	for _, input := range svc.RunTaskInputs {
+
+		cmd := strings.Join(aws.StringValueSlice(input.Overrides.ContainerOverrides[0].Command), " ")
+		is_report := strings.Contains(cmd, "-report -report-name x.json")
+
+		switch {
+		case strings.Contains(cmd, "file:///a.jpg"):
+
+			if is_report {
+				t.Fatalf("Expected job request a not to report, got %s", cmd)
+			}
+
+		case strings.Contains(cmd, "file:///b.jpg"):
+
+			if !is_report {
+				t.Fatalf("Expected job request b to report, got %s", cmd)
+			}
+
+		default:
+			t.Fatalf("Unexpected command %s", cmd)
+		}
+
+		job_ids[aws.StringValue(input.StartedBy)] = true
+	}
+
+	if len(job_ids) != 2 {
+		t.Fatalf("Expected each job request to have its own job ID, got %v", job_ids)
+	}
+
+	// job requests that differ only by callback aren't grouped either
+
+	tasks_a, err := sqsBodyTasks(opts, msgs[0].Body)
+
+	if err != nil {
+		t.Fatalf("Failed to parse message, %s", err)
+	}
+
+	tasks_c, err := sqsBodyTasks(opts, msgs[2].Body)
+
+	if err != nil {
+		t.Fatalf("Failed to parse message, %s", err)
+	}
+
+	tasks_c[0].JobId = tasks_a[0].JobId
+
+	if sqsTaskKey(tasks_a[0]) == sqsTaskKey(tasks_c[0]) {
+		t.Fatal("Expected job requests with different callbacks to be grouped separately")
+	}
+}
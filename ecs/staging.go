@@ -0,0 +1,236 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/whosonfirst/go-whosonfirst-aws/session"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const DEFAULT_STAGING_PREFIX string = "iiif-process/"
+
+// Staging uploads per-job instructions documents and URI manifests (see StageManifest) to an S3
+// bucket so that tasks can read them. Files are named {PREFIX}{JOB_ID}/instructions-{SHA256}.json
+// and {PREFIX}{JOB_ID}/manifest-{SHA256}.jsonl so that jobs never share (or remove) each other's files.
+type Staging struct {
+	// If true staged instructions are removed once the job's tasks have stopped. Manifests are
+	// always removed once their task has stopped.
+	Cleanup bool
+	service S3Service
+	source  string
+	bucket  string
+	prefix  string
+}
+
+// NewStagingWithDSN returns a Staging for source, a Go Cloud S3 bucket URI (for example
+// "s3://bucket?region=us-east-1"). If source has a region parameter it overrides the region in dsn.
+// If dsn is empty the default AWS credential chain (for example a task's role) is used.
+func NewStagingWithDSN(source string, prefix string, dsn string) (*Staging, error) {
+
+	_, region, err := parseStagingSource(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := aws.NewConfig()
+
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+
+	var sess *aws_session.Session
+
+	if dsn == "" {
+		sess, err = aws_session.NewSession(cfg)
+	} else {
+		sess, err = session.NewSessionWithDSN(dsn)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := s3.New(sess, cfg)
+
+	return NewStaging(svc, source, prefix)
+}
+
+func NewStaging(svc S3Service, source string, prefix string) (*Staging, error) {
+
+	bucket, _, err := parseStagingSource(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := Staging{
+		service: svc,
+		source:  source,
+		bucket:  bucket,
+		prefix:  prefix,
+	}
+
+	return &s, nil
+}
+
+func parseStagingSource(source string) (string, string, error) {
+
+	err := ValidateBucketURI(source)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	u, err := url.Parse(source)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if u.Scheme != "s3" || u.Host == "" {
+		msg := fmt.Sprintf("Invalid staging bucket '%s', expected s3://{BUCKET}", source)
+		return "", "", errors.New(msg)
+	}
+
+	return u.Host, u.Query().Get("region"), nil
+}
+
+// Source returns the bucket URI that staged instructions should be read from.
+func (s *Staging) Source() string {
+	return s.source
+}
+
+// InstructionsName returns the name that the instructions document body is staged as for job_id.
+func (s *Staging) InstructionsName(job_id string, body []byte) string {
+	return fmt.Sprintf("%s%s/instructions-%x.json", s.prefix, job_id, sha256.Sum256(body))
+}
+
+// IsStaged reports whether the file named name in the bucket source was staged by s.
+func (s *Staging) IsStaged(source string, name string) bool {
+	return source == s.source && strings.HasPrefix(name, s.prefix)
+}
+
+// StageInstructions validates and uploads the instructions document body for job_id and
+// returns its name.
+func (s *Staging) StageInstructions(ctx context.Context, job_id string, body []byte) (string, error) {
+
+	err := ValidateInstructions(body)
+
+	if err != nil {
+		return "", err
+	}
+
+	name := s.InstructionsName(job_id, body)
+
+	err = s.put(ctx, name, body, "application/json")
+
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+func (s *Staging) put(ctx context.Context, name string, body []byte, content_type string) error {
+
+	req := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(name),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String(content_type),
+	}
+
+	_, err := s.service.PutObjectWithContext(ctx, req)
+	return err
+}
+
+func (s *Staging) get(ctx context.Context, name string) ([]byte, error) {
+
+	req := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(name),
+	}
+
+	rsp, err := s.service.GetObjectWithContext(ctx, req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+
+	return ioutil.ReadAll(rsp.Body)
+}
+
+// Remove removes the staged file named name.
+func (s *Staging) Remove(ctx context.Context, name string) error {
+
+	req := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(name),
+	}
+
+	_, err := s.service.DeleteObjectWithContext(ctx, req)
+	return err
+}
+
+// stageInstructions uploads opts.InstructionsDocument, if there is one, to opts.Staging and
+// returns a copy of opts that points to it along with its name.
+func stageInstructions(ctx context.Context, opts *ProcessTaskOptions) (*ProcessTaskOptions, string, error) {
+
+	if len(opts.InstructionsDocument) == 0 {
+		return opts, "", nil
+	}
+
+	if opts.Staging == nil {
+		return nil, "", errors.New("Instructions documents require a staging bucket")
+	}
+
+	name, err := opts.Staging.StageInstructions(ctx, opts.JobId, opts.InstructionsDocument)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	staged_opts := *opts
+	staged_opts.InstructionsDocument = nil
+	staged_opts.Instructions = ""
+	staged_opts.InstructionsSource = opts.Staging.Source()
+	staged_opts.InstructionsName = name
+
+	return &staged_opts, name, nil
+}
+
+// cleanupInstructions removes the staged instructions named name if opts.Staging.Cleanup is true.
+func cleanupInstructions(ctx context.Context, opts *ProcessTaskOptions, name string) {
+
+	if opts.Staging == nil || !opts.Staging.Cleanup {
+		return
+	}
+
+	removeStaged(ctx, opts, name)
+}
+
+// removeStaged removes the file named name from opts.Staging, logging any errors.
+func removeStaged(ctx context.Context, opts *ProcessTaskOptions, name string) {
+
+	if name == "" || opts.Staging == nil {
+		return
+	}
+
+	err := opts.Staging.Remove(ctx, name)
+
+	if err != nil {
+		log.Printf("[WARNING] Failed to remove staged file %s: %s\n", name, err)
+	}
+}
@@ -0,0 +1,428 @@
+package ecs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3Service is an in-memory S3Service for a single bucket. Range requests are supported
+// for the "bytes={START}-{END}" form only.
+type fakeS3Service struct {
+	mu      *sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3Service() *fakeS3Service {
+
+	svc := fakeS3Service{
+		mu:      new(sync.Mutex),
+		objects: make(map[string][]byte),
+	}
+
+	return &svc
+}
+
+// Keys returns the (sorted) keys of the objects in the bucket.
+func (svc *fakeS3Service) Keys() []string {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	keys := make([]string, 0)
+
+	for k := range svc.objects {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func (svc *fakeS3Service) Put(key string, body []byte) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	svc.objects[key] = body
+}
+
+func (svc *fakeS3Service) Get(key string) ([]byte, bool) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	body, ok := svc.objects[key]
+	return body, ok
+}
+
+func (svc *fakeS3Service) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+
+	body, ok := svc.Get(aws.StringValue(input.Key))
+
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+
+	total := int64(len(body))
+
+	rsp := &s3.GetObjectOutput{
+		ContentLength: aws.Int64(total),
+	}
+
+	if input.Range != nil {
+
+		var start int64
+		var end int64
+
+		_, err := fmt.Sscanf(aws.StringValue(input.Range), "bytes=%d-%d", &start, &end)
+
+		if err != nil || start >= total {
+			return nil, awserr.New("InvalidRange", "The requested range is not satisfiable", err)
+		}
+
+		if end >= total {
+			end = total - 1
+		}
+
+		body = body[start : end+1]
+
+		rsp.ContentLength = aws.Int64(int64(len(body)))
+		rsp.ContentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, total))
+	}
+
+	rsp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return rsp, nil
+}
+
+func (svc *fakeS3Service) GetObjectWithContext(ctx aws.Context, input *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	return svc.GetObject(input)
+}
+
+func (svc *fakeS3Service) PutObjectWithContext(ctx aws.Context, input *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+
+	body, err := ioutil.ReadAll(input.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc.Put(aws.StringValue(input.Key), body)
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (svc *fakeS3Service) DeleteObjectWithContext(ctx aws.Context, input *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	// like S3, deleting a missing object is not an error
+
+	delete(svc.objects, aws.StringValue(input.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func TestNewStaging(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		source string
+		valid  bool
+	}{
+		{"bucket", "s3://staging", true},
+		{"bucket with region", "s3://staging?region=us-west-2", true},
+		{"empty", "", false},
+		{"missing scheme", "staging", false},
+		{"missing bucket", "s3://", false},
+		{"not s3", "file:///tmp/staging", false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			s, err := NewStaging(newFakeS3Service(), tt.source, DEFAULT_STAGING_PREFIX)
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected %s to be valid, %s", tt.source, err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatalf("Expected %s to be invalid", tt.source)
+			}
+
+			if tt.valid && s.Source() != tt.source {
+				t.Fatalf("Expected source %s, got %s", tt.source, s.Source())
+			}
+		})
+	}
+}
+
+func TestStagingInstructionsName(t *testing.T) {
+
+	s, err := NewStaging(newFakeS3Service(), "s3://staging", DEFAULT_STAGING_PREFIX)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging, %s", err)
+	}
+
+	body := []byte(`{"o": {"size": "full"}}`)
+
+	expected := fmt.Sprintf("iiif-process/iiif-test/instructions-%x.json", sha256.Sum256(body))
+
+	name := s.InstructionsName("iiif-test", body)
+
+	if name != expected {
+		t.Fatalf("Expected name %s, got %s", expected, name)
+	}
+
+	if s.InstructionsName("iiif-test", body) != name {
+		t.Fatal("Expected the same document to have the same name")
+	}
+
+	if s.InstructionsName("iiif-test", []byte(`{"o": {"size": "!4000,4000"}}`)) == name {
+		t.Fatal("Expected different documents to have different names")
+	}
+
+	if s.InstructionsName("iiif-other", body) == name {
+		t.Fatal("Expected different jobs to have different names")
+	}
+
+	if !s.IsStaged("s3://staging", name) {
+		t.Fatalf("Expected %s to be staged", name)
+	}
+
+	if s.IsStaged("s3://other", name) {
+		t.Fatalf("Expected %s in another bucket not to be staged", name)
+	}
+
+	if s.IsStaged("s3://staging", "instructions.json") {
+		t.Fatal("Expected file outside the prefix not to be staged")
+	}
+}
+
+func TestValidateInstructions(t *testing.T) {
+
+	tests := []struct {
+		name         string
+		instructions string
+		valid        bool
+	}{
+		{"one label", `{"o": {"size": "full", "format": "", "rotation": "-1"}}`, true},
+		{"many labels", `{"o": {"size": "full"}, "b": {"size": "!2048,1536", "format": "png"}}`, true},
+		{"empty instruction", `{"o": {}}`, true},
+		{"no labels", `{}`, false},
+		{"empty label", `{" ": {"size": "full"}}`, false},
+		{"null instruction", `{"o": null}`, false},
+		{"not an instruction", `{"o": "full"}`, false},
+		{"array", `[{"size": "full"}]`, false},
+		{"null", `null`, false},
+		{"invalid", `{"o":`, false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			err := ValidateInstructions([]byte(tt.instructions))
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected %s to be valid, %s", tt.instructions, err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatalf("Expected %s to be invalid", tt.instructions)
+			}
+		})
+	}
+}
+
+func TestStageInstructions(t *testing.T) {
+
+	svc := newFakeS3Service()
+
+	s, err := NewStaging(svc, "s3://staging", DEFAULT_STAGING_PREFIX)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging, %s", err)
+	}
+
+	ctx := context.Background()
+
+	body := []byte(`{"o": {"size": "full"}}`)
+
+	name, err := s.StageInstructions(ctx, "iiif-test", body)
+
+	if err != nil {
+		t.Fatalf("Failed to stage instructions, %s", err)
+	}
+
+	if name != s.InstructionsName("iiif-test", body) {
+		t.Fatalf("Unexpected name %s", name)
+	}
+
+	staged, ok := svc.Get(name)
+
+	if !ok || string(staged) != string(body) {
+		t.Fatalf("Expected %s to contain instructions, got '%s'", name, string(staged))
+	}
+
+	_, err = s.StageInstructions(ctx, "iiif-test", []byte(`{"o": null}`))
+
+	if err == nil {
+		t.Fatal("Expected invalid instructions to be rejected")
+	}
+
+	if len(svc.Keys()) != 1 {
+		t.Fatalf("Expected invalid instructions not to be staged, got %v", svc.Keys())
+	}
+
+	err = s.Remove(ctx, name)
+
+	if err != nil {
+		t.Fatalf("Failed to remove %s, %s", name, err)
+	}
+
+	if len(svc.Keys()) != 0 {
+		t.Fatalf("Expected %s to be removed, got %v", name, svc.Keys())
+	}
+}
+
+func TestLaunchProcessTaskStagedInstructions(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		wait     bool
+		cleanup  bool
+		failures []string
+		staged   bool
+	}{
+		// removed by the task state change handler once the task stops
+		{"launched", false, true, nil, true},
+		{"waited", true, true, nil, false},
+		{"waited without cleanup", true, false, nil, true},
+		{"launch failed", false, true, []string{"MISSING"}, false},
+		{"launch failed without cleanup", false, false, []string{"MISSING"}, true},
+	}
+
+	body := []byte(`{"o": {"size": "!4000,4000", "format": "tif"}}`)
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			s3_svc := newFakeS3Service()
+
+			staging, err := NewStaging(s3_svc, "s3://staging", DEFAULT_STAGING_PREFIX)
+
+			if err != nil {
+				t.Fatalf("Failed to create staging, %s", err)
+			}
+
+			staging.Cleanup = tt.cleanup
+
+			opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+			opts.JobId = "iiif-test"
+			opts.Staging = staging
+			opts.InstructionsDocument = body
+			opts.Wait = tt.wait
+
+			svc := NewFakeECSService()
+			svc.RunTaskFailures = tt.failures
+
+			l := NewProcessTaskLauncher(svc, nil)
+			l.SetPollInterval(time.Millisecond)
+
+			_, err = l.LaunchProcessTask(context.Background(), opts)
+
+			if tt.failures == nil && err != nil {
+				t.Fatalf("Failed to launch task, %s", err)
+			}
+
+			if tt.failures != nil && err == nil {
+				t.Fatal("Expected task to fail to launch")
+			}
+
+			name := staging.InstructionsName(opts.JobId, body)
+
+			_, staged := s3_svc.Get(name)
+
+			if staged != tt.staged {
+				t.Fatalf("Expected %s staged to be %t, got %t", name, tt.staged, staged)
+			}
+
+			if len(svc.RunTaskInputs) == 0 {
+				t.Fatal("Expected RunTask to be called")
+			}
+
+			// tasks read the staged instructions from the staging bucket
+
+			cmd := aws.StringValueSlice(svc.RunTaskInputs[0].Overrides.ContainerOverrides[0].Command)
+			str_cmd := strings.Join(cmd, " ")
+
+			expected := "-instructions-source s3://staging -instructions-name " + name
+
+			if !strings.Contains(str_cmd, expected) {
+				t.Fatalf("Expected command to contain '%s', got '%s'", expected, str_cmd)
+			}
+
+			if strings.Contains(str_cmd, "-instructions ") {
+				t.Fatalf("Expected command not to use the default instructions, got '%s'", str_cmd)
+			}
+		})
+	}
+}
+
+func TestLaunchProcessTaskStagedInstructionsInvalid(t *testing.T) {
+
+	s3_svc := newFakeS3Service()
+
+	staging, err := NewStaging(s3_svc, "s3://staging", DEFAULT_STAGING_PREFIX)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging, %s", err)
+	}
+
+	tests := []struct {
+		name         string
+		staging      *Staging
+		instructions string
+	}{
+		{"invalid", staging, `{"o": "full"}`},
+		{"no staging bucket", nil, `{"o": {"size": "full"}}`},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+			opts.Staging = tt.staging
+			opts.InstructionsDocument = []byte(tt.instructions)
+
+			svc := NewFakeECSService()
+			l := NewProcessTaskLauncher(svc, nil)
+
+			_, err := l.LaunchProcessTask(context.Background(), opts)
+
+			if err == nil {
+				t.Fatal("Expected task to be rejected")
+			}
+
+			if len(svc.RunTaskInputs) != 0 || len(s3_svc.Keys()) != 0 {
+				t.Fatalf("Expected nothing to be launched or staged, got %d tasks and %v", len(svc.RunTaskInputs), s3_svc.Keys())
+			}
+		})
+	}
+}
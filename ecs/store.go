@@ -0,0 +1,168 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"log"
+	"net/url"
+	"time"
+)
+
+// JobStore records which URIs were sent to which task and how that turned out. There is one
+// JobRecord for each URI in each task.
+type JobStore interface {
+	// PutJobRecords adds records to the store, replacing any existing records for the same
+	// URI and task.
+	PutJobRecords(context.Context, []*JobRecord) error
+	JobRecordsForJobId(context.Context, string) ([]*JobRecord, error)
+	JobRecordsForURI(context.Context, string) ([]*JobRecord, error)
+	Close() error
+}
+
+type JobRecord struct {
+	URI                string         `json:"uri"`
+	TaskId             string         `json:"task_id"`
+	JobId              string         `json:"job_id,omitempty"`
+	Cluster            string         `json:"cluster,omitempty"`
+	TaskDefinition     string         `json:"task_definition,omitempty"`
+	Config             string         `json:"config,omitempty"`
+	ConfigSource       string         `json:"config_source,omitempty"`
+	ConfigName         string         `json:"config_name,omitempty"`
+	Instructions       string         `json:"instructions,omitempty"`
+	InstructionsSource string         `json:"instructions_source,omitempty"`
+	InstructionsName   string         `json:"instructions_name,omitempty"`
+	Report             bool           `json:"report,omitempty"`
+	ReportName         string         `json:"report_name,omitempty"`
+	Status             string         `json:"status"`
+	ExitCode           *int64         `json:"exit_code,omitempty"`
+	StoppedReason      string         `json:"stopped_reason,omitempty"`
+	Error              string         `json:"error,omitempty"`
+	Result             *ProcessResult `json:"result,omitempty"`
+	CreatedAt          time.Time      `json:"created_at"`
+	UpdatedAt          time.Time      `json:"updated_at"`
+	StartedAt          *time.Time     `json:"started_at,omitempty"`
+	StoppedAt          *time.Time     `json:"stopped_at,omitempty"`
+}
+
+// NewJobStore returns a JobStore for str_uri, which may be one of:
+//
+//	file:///path/to/jobs.json  A local JSON file (see FileJobStore).
+//	dynamodb://{TABLE}          A DynamoDB table (see DynamoDBJobStore), using dsn.
+func NewJobStore(str_uri string, dsn string) (JobStore, error) {
+
+	u, err := url.Parse(str_uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewFileJobStore(u.Path)
+	case "dynamodb":
+		return NewDynamoDBJobStoreWithDSN(dsn, u.Host)
+	default:
+		msg := fmt.Sprintf("Unsupported job store '%s'", u.Scheme)
+		return nil, errors.New(msg)
+	}
+}
+
+// NewJobRecords returns a JobRecord for each of the URIs in rsp. If err is not nil it is
+// recorded as the reason the task failed.
+func NewJobRecords(opts *ProcessTaskOptions, rsp *ProcessTaskResponse, err error) []*JobRecord {
+
+	now := time.Now()
+
+	// use the time the task was created, rather than now, so that records for the
+	// same task always have the same creation time
+
+	created := now
+
+	if rsp.CreatedAt != nil {
+		created = *rsp.CreatedAt
+	}
+
+	var exit_code *int64
+
+	for _, c := range rsp.Containers {
+
+		if c.Name == opts.Container && c.ExitCode != nil {
+			exit_code = aws.Int64(*c.ExitCode)
+		}
+	}
+
+	records := make([]*JobRecord, len(rsp.URIs))
+
+	for i, u := range rsp.URIs {
+
+		str_uri := u.String()
+
+		r := &JobRecord{
+			URI:                str_uri,
+			TaskId:             rsp.TaskId,
+			JobId:              rsp.JobId,
+			Cluster:            opts.Cluster,
+			TaskDefinition:     opts.Task,
+			Config:             opts.Config,
+			ConfigSource:       opts.ConfigSource,
+			ConfigName:         opts.ConfigName,
+			Instructions:       opts.Instructions,
+			InstructionsSource: opts.InstructionsSource,
+			InstructionsName:   opts.InstructionsName,
+			Report:             opts.Report,
+			Status:             rsp.Status,
+			ExitCode:           exit_code,
+			StoppedReason:      rsp.StoppedReason,
+			CreatedAt:          created,
+			UpdatedAt:          now,
+			StartedAt:          rsp.StartedAt,
+			StoppedAt:          rsp.StoppedAt,
+		}
+
+		if opts.Report {
+			r.ReportName = opts.ReportName
+		}
+
+		if err != nil {
+			r.Error = err.Error()
+		}
+
+		// iiif-process keys its results by origin (for example "zuber.jpg" for
+		// "file:///zuber.jpg?target=zuber") rather than by URI
+
+		if rsp.Results != nil {
+
+			result, ok := rsp.Results[u.Origin()]
+
+			if !ok {
+				result = rsp.Results[str_uri]
+			}
+
+			r.Result = result
+		}
+
+		records[i] = r
+	}
+
+	return records
+}
+
+// recordTask writes the current state of rsp to opts.Store, if there is one. Errors are
+// logged rather than returned since a task that has been launched shouldn't be reported as
+// failing just because it couldn't be recorded.
+func (l *ProcessTaskLauncher) recordTask(ctx context.Context, opts *ProcessTaskOptions, rsp *ProcessTaskResponse, task_err error) {
+
+	if opts.Store == nil {
+		return
+	}
+
+	records := NewJobRecords(opts, rsp, task_err)
+
+	err := opts.Store.PutJobRecords(ctx, records)
+
+	if err != nil {
+		log.Printf("[WARNING] Failed to record task %s: %s\n", rsp.TaskId, err)
+	}
+}
@@ -0,0 +1,149 @@
+package ecs
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/whosonfirst/go-whosonfirst-aws/session"
+	"sort"
+)
+
+// DYNAMODB_JOB_ID_INDEX is the name of the global secondary index, on the "job_id" (partition
+// key) and "task_id" (sort key) attributes, used to look up records by job ID.
+const DYNAMODB_JOB_ID_INDEX string = "job_id"
+
+type DynamoDBService interface {
+	PutItemWithContext(aws.Context, *dynamodb.PutItemInput, ...request.Option) (*dynamodb.PutItemOutput, error)
+	QueryPagesWithContext(aws.Context, *dynamodb.QueryInput, func(*dynamodb.QueryOutput, bool) bool, ...request.Option) error
+}
+
+// DynamoDBJobStore is a JobStore backed by a DynamoDB table whose partition key is "uri" and
+// whose sort key is "task_id" (both strings), with a global secondary index for job IDs (see
+// DYNAMODB_JOB_ID_INDEX).
+type DynamoDBJobStore struct {
+	service DynamoDBService
+	table   string
+}
+
+var _ JobStore = (*DynamoDBJobStore)(nil)
+
+func NewDynamoDBJobStoreWithDSN(dsn string, table string) (JobStore, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := dynamodb.New(sess)
+
+	return NewDynamoDBJobStore(svc, table), nil
+}
+
+func NewDynamoDBJobStore(svc DynamoDBService, table string) JobStore {
+
+	s := DynamoDBJobStore{
+		service: svc,
+		table:   table,
+	}
+
+	return &s
+}
+
+func (s *DynamoDBJobStore) PutJobRecords(ctx context.Context, records []*JobRecord) error {
+
+	for _, r := range records {
+
+		item, err := dynamodbattribute.MarshalMap(r)
+
+		if err != nil {
+			return err
+		}
+
+		put := &dynamodb.PutItemInput{
+			TableName: aws.String(s.table),
+			Item:      item,
+		}
+
+		_, err = s.service.PutItemWithContext(ctx, put)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *DynamoDBJobStore) JobRecordsForJobId(ctx context.Context, job_id string) ([]*JobRecord, error) {
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		IndexName:              aws.String(DYNAMODB_JOB_ID_INDEX),
+		KeyConditionExpression: aws.String("job_id = :job_id"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":job_id": {
+				S: aws.String(job_id),
+			},
+		},
+	}
+
+	return s.query(ctx, input)
+}
+
+func (s *DynamoDBJobStore) JobRecordsForURI(ctx context.Context, str_uri string) ([]*JobRecord, error) {
+
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("uri = :uri"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":uri": {
+				S: aws.String(str_uri),
+			},
+		},
+	}
+
+	return s.query(ctx, input)
+}
+
+func (s *DynamoDBJobStore) Close() error {
+	return nil
+}
+
+func (s *DynamoDBJobStore) query(ctx context.Context, input *dynamodb.QueryInput) ([]*JobRecord, error) {
+
+	records := make([]*JobRecord, 0)
+
+	var unmarshal_err error
+
+	err := s.service.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, last bool) bool {
+
+		page_records := make([]*JobRecord, 0)
+
+		err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &page_records)
+
+		if err != nil {
+			unmarshal_err = err
+			return false
+		}
+
+		records = append(records, page_records...)
+		return true
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if unmarshal_err != nil {
+		return nil, unmarshal_err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+
+	return records, nil
+}
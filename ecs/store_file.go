@@ -0,0 +1,178 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// FileJobStore is a JobStore backed by a single (JSON-encoded) file on the local filesystem.
+// The file is re-read for every operation so that it can be queried while other processes
+// (in the same host) are writing to it, but it is not meant to handle very many records.
+type FileJobStore struct {
+	path string
+	mu   *sync.Mutex
+}
+
+var _ JobStore = (*FileJobStore)(nil)
+
+func NewFileJobStore(path string) (JobStore, error) {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := FileJobStore{
+		path: abs_path,
+		mu:   new(sync.Mutex),
+	}
+
+	return &s, nil
+}
+
+func (s *FileJobStore) PutJobRecords(ctx context.Context, records []*JobRecord) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, err := s.read()
+
+	if err != nil {
+		return err
+	}
+
+	lookup := make(map[string]int)
+
+	for i, r := range existing {
+		lookup[r.URI+"#"+r.TaskId] = i
+	}
+
+	for _, r := range records {
+
+		k := r.URI + "#" + r.TaskId
+
+		i, ok := lookup[k]
+
+		if !ok {
+			lookup[k] = len(existing)
+			existing = append(existing, r)
+			continue
+		}
+
+		existing[i] = r
+	}
+
+	return s.write(existing)
+}
+
+func (s *FileJobStore) JobRecordsForJobId(ctx context.Context, job_id string) ([]*JobRecord, error) {
+
+	return s.filter(func(r *JobRecord) bool {
+		return r.JobId == job_id
+	})
+}
+
+func (s *FileJobStore) JobRecordsForURI(ctx context.Context, str_uri string) ([]*JobRecord, error) {
+
+	return s.filter(func(r *JobRecord) bool {
+		return r.URI == str_uri
+	})
+}
+
+func (s *FileJobStore) Close() error {
+	return nil
+}
+
+func (s *FileJobStore) filter(match func(*JobRecord) bool) ([]*JobRecord, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, err := s.read()
+
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]*JobRecord, 0)
+
+	for _, r := range records {
+
+		if match(r) {
+			matches = append(matches, r)
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].CreatedAt.Before(matches[j].CreatedAt)
+	})
+
+	return matches, nil
+}
+
+func (s *FileJobStore) read() ([]*JobRecord, error) {
+
+	records := make([]*JobRecord, 0)
+
+	body, err := ioutil.ReadFile(s.path)
+
+	if err != nil {
+
+		if os.IsNotExist(err) {
+			return records, nil
+		}
+
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		return records, nil
+	}
+
+	err = json.Unmarshal(body, &records)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// write replaces the store's file atomically, so that readers never see a partial file.
+func (s *FileJobStore) write(records []*JobRecord) error {
+
+	body, err := json.MarshalIndent(records, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(body)
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = tmp.Close()
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
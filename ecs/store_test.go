@@ -0,0 +1,308 @@
+package ecs
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDynamoDBService is an in-memory DynamoDBService for a table whose partition key is
+// "uri" and whose sort key is "task_id". It only understands the key conditions used by
+// DynamoDBJobStore and returns query results one item per page.
+type fakeDynamoDBService struct {
+	Queries []*dynamodb.QueryInput
+	mu      *sync.Mutex
+	items   []map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamoDBService() *fakeDynamoDBService {
+
+	svc := fakeDynamoDBService{
+		Queries: make([]*dynamodb.QueryInput, 0),
+		mu:      new(sync.Mutex),
+		items:   make([]map[string]*dynamodb.AttributeValue, 0),
+	}
+
+	return &svc
+}
+
+func (svc *fakeDynamoDBService) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	k := aws.StringValue(input.Item["uri"].S) + "#" + aws.StringValue(input.Item["task_id"].S)
+
+	for i, item := range svc.items {
+
+		if aws.StringValue(item["uri"].S)+"#"+aws.StringValue(item["task_id"].S) == k {
+			svc.items[i] = input.Item
+			return &dynamodb.PutItemOutput{}, nil
+		}
+	}
+
+	svc.items = append(svc.items, input.Item)
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (svc *fakeDynamoDBService) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	svc.Queries = append(svc.Queries, input)
+
+	// for example "job_id = :job_id"
+
+	parts := strings.Split(aws.StringValue(input.KeyConditionExpression), " = ")
+	attr := parts[0]
+	value := aws.StringValue(input.ExpressionAttributeValues[parts[1]].S)
+
+	matches := make([]map[string]*dynamodb.AttributeValue, 0)
+
+	for _, item := range svc.items {
+
+		v, ok := item[attr]
+
+		if ok && aws.StringValue(v.S) == value {
+			matches = append(matches, item)
+		}
+	}
+
+	if len(matches) == 0 {
+		fn(&dynamodb.QueryOutput{}, true)
+		return nil
+	}
+
+	for i, item := range matches {
+
+		page := &dynamodb.QueryOutput{
+			Items: []map[string]*dynamodb.AttributeValue{item},
+		}
+
+		if !fn(page, i == len(matches)-1) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func newTestJobRecords(now time.Time) []*JobRecord {
+
+	return []*JobRecord{
+		&JobRecord{URI: "file:///b.jpg", TaskId: "task-2", JobId: "job-2", Status: TASK_STATUS_PENDING, CreatedAt: now.Add(time.Minute)},
+		&JobRecord{URI: "file:///a.jpg", TaskId: "task-1", JobId: "job-1", Status: TASK_STATUS_PENDING, CreatedAt: now},
+		&JobRecord{URI: "file:///b.jpg", TaskId: "task-1", JobId: "job-1", Status: TASK_STATUS_PENDING, CreatedAt: now},
+	}
+}
+
+// testJobStore exercises store, which must be empty.
+func testJobStore(t *testing.T, store JobStore) {
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	err := store.PutJobRecords(ctx, newTestJobRecords(now))
+
+	if err != nil {
+		t.Fatalf("Failed to put records, %s", err)
+	}
+
+	// updating a record for the same URI and task replaces it
+
+	exit_code := int64(0)
+
+	update := &JobRecord{URI: "file:///b.jpg", TaskId: "task-1", JobId: "job-1", Status: TASK_STATUS_STOPPED, ExitCode: &exit_code, CreatedAt: now}
+
+	err = store.PutJobRecords(ctx, []*JobRecord{update})
+
+	if err != nil {
+		t.Fatalf("Failed to update record, %s", err)
+	}
+
+	records, err := store.JobRecordsForURI(ctx, "file:///b.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to read records, %s", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records for file:///b.jpg, got %d", len(records))
+	}
+
+	// records are sorted by creation time
+
+	if records[0].TaskId != "task-1" || records[1].TaskId != "task-2" {
+		t.Fatalf("Unexpected records order %s, %s", records[0].TaskId, records[1].TaskId)
+	}
+
+	if records[0].Status != TASK_STATUS_STOPPED || records[0].ExitCode == nil || *records[0].ExitCode != 0 {
+		t.Fatalf("Expected record to have been updated, got %v", records[0])
+	}
+
+	if !records[0].CreatedAt.Equal(now) {
+		t.Fatalf("Expected creation time %v, got %v", now, records[0].CreatedAt)
+	}
+
+	records, err = store.JobRecordsForJobId(ctx, "job-1")
+
+	if err != nil {
+		t.Fatalf("Failed to read records, %s", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records for job-1, got %d", len(records))
+	}
+
+	for _, r := range records {
+
+		if r.JobId != "job-1" || r.TaskId != "task-1" {
+			t.Fatalf("Unexpected record for job-1 %v", r)
+		}
+	}
+
+	records, err = store.JobRecordsForURI(ctx, "file:///missing.jpg")
+
+	if err != nil {
+		t.Fatalf("Failed to read records, %s", err)
+	}
+
+	if len(records) != 0 {
+		t.Fatalf("Expected no records, got %d", len(records))
+	}
+}
+
+func TestFileJobStore(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "jobs")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jobs.json")
+
+	store, err := NewFileJobStore(path)
+
+	if err != nil {
+		t.Fatalf("Failed to create job store, %s", err)
+	}
+
+	testJobStore(t, store)
+
+	// the file is rewritten atomically, by way of a temporary file in the same directory
+	// which should not be left behind
+
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		t.Fatalf("Failed to read directory, %s", err)
+	}
+
+	if len(files) != 1 || files[0].Name() != "jobs.json" {
+		t.Fatalf("Expected only jobs.json, got %v", files)
+	}
+
+	// and re-read for every operation so that other stores for the same file see changes
+
+	other, err := NewFileJobStore(path)
+
+	if err != nil {
+		t.Fatalf("Failed to create job store, %s", err)
+	}
+
+	records, err := other.JobRecordsForJobId(context.Background(), "job-2")
+
+	if err != nil {
+		t.Fatalf("Failed to read records, %s", err)
+	}
+
+	if len(records) != 1 || records[0].URI != "file:///b.jpg" {
+		t.Fatalf("Expected one record for job-2, got %v", records)
+	}
+}
+
+func TestFileJobStoreEmptyFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "jobs")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "jobs.json")
+
+	err = ioutil.WriteFile(path, []byte(""), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %s", path, err)
+	}
+
+	store, err := NewFileJobStore(path)
+
+	if err != nil {
+		t.Fatalf("Failed to create job store, %s", err)
+	}
+
+	records, err := store.JobRecordsForJobId(context.Background(), "job-1")
+
+	if err != nil {
+		t.Fatalf("Failed to read empty store, %s", err)
+	}
+
+	if len(records) != 0 {
+		t.Fatalf("Expected no records, got %d", len(records))
+	}
+
+	err = ioutil.WriteFile(path, []byte("{"), 0644)
+
+	if err != nil {
+		t.Fatalf("Failed to write %s, %s", path, err)
+	}
+
+	_, err = store.JobRecordsForJobId(context.Background(), "job-1")
+
+	if err == nil {
+		t.Fatal("Expected an invalid store to fail")
+	}
+}
+
+func TestDynamoDBJobStore(t *testing.T) {
+
+	svc := newFakeDynamoDBService()
+	store := NewDynamoDBJobStore(svc, "iiif-process-jobs")
+
+	testJobStore(t, store)
+
+	for _, q := range svc.Queries {
+
+		if aws.StringValue(q.TableName) != "iiif-process-jobs" {
+			t.Fatalf("Unexpected table %s", aws.StringValue(q.TableName))
+		}
+
+		is_job_query := strings.HasPrefix(aws.StringValue(q.KeyConditionExpression), "job_id ")
+
+		// job IDs are looked up using the global secondary index
+
+		if is_job_query && aws.StringValue(q.IndexName) != DYNAMODB_JOB_ID_INDEX {
+			t.Fatalf("Expected job ID query to use index %s, got '%s'", DYNAMODB_JOB_ID_INDEX, aws.StringValue(q.IndexName))
+		}
+
+		if !is_job_query && q.IndexName != nil {
+			t.Fatalf("Expected URI query to use the table, got index '%s'", aws.StringValue(q.IndexName))
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The tags added to every task launched by LaunchProcessTask. Job IDs are also recorded as
+// the task's startedBy property, if they are short enough, but a tag is more reliable.
+const JOB_ID_TAG string = "iiif-process:job-id"
+
+const URI_COUNT_TAG string = "iiif-process:uri-count"
+
+const TRIGGER_TAG string = "iiif-process:trigger"
+
+// ParseTags parses a list of "KEY=VALUE" strings in to a map of tags.
+func ParseTags(str_tags []string) (map[string]string, error) {
+
+	tags := make(map[string]string)
+
+	for _, str_tag := range str_tags {
+
+		parts := strings.SplitN(str_tag, "=", 2)
+
+		if len(parts) != 2 || parts[0] == "" {
+			msg := fmt.Sprintf("Invalid tag '%s', expected KEY=VALUE", str_tag)
+			return nil, errors.New(msg)
+		}
+
+		if strings.HasPrefix(strings.ToLower(parts[0]), "aws:") {
+			msg := fmt.Sprintf("Invalid tag '%s', the aws: prefix is reserved", str_tag)
+			return nil, errors.New(msg)
+		}
+
+		tags[parts[0]] = parts[1]
+	}
+
+	return tags, nil
+}
+
+// TaskTags returns the tags for a task launched with opts: the tags in opts.Tags and the job
+// ID, URI count and trigger tags (which take precedence).
+func TaskTags(opts *ProcessTaskOptions) []*aws_ecs.Tag {
+
+	tags := make(map[string]string)
+
+	for k, v := range opts.Tags {
+		tags[k] = v
+	}
+
+	if opts.JobId != "" {
+		tags[JOB_ID_TAG] = opts.JobId
+	}
+
+	tags[URI_COUNT_TAG] = strconv.Itoa(len(opts.URIs))
+
+	if opts.Trigger != "" {
+		tags[TRIGGER_TAG] = opts.Trigger
+	}
+
+	keys := make([]string, 0, len(tags))
+
+	for k := range tags {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	task_tags := make([]*aws_ecs.Tag, len(keys))
+
+	for i, k := range keys {
+		task_tags[i] = &aws_ecs.Tag{
+			Key:   aws.String(k),
+			Value: aws.String(tags[k]),
+		}
+	}
+
+	return task_tags
+}
@@ -0,0 +1,241 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/go-iiif/go-iiif-uri"
+	"strings"
+	"time"
+)
+
+type ContainerStatus struct {
+	Name     string
+	ExitCode *int64 `json:",omitempty"`
+	Reason   string `json:",omitempty"`
+}
+
+// TaskExitError is returned when a task has stopped and one of its containers either
+// exited with a non-zero status or never reported an exit code at all (for example
+// because its image could not be pulled), in which case ExitCode is -1.
+type TaskExitError struct {
+	TaskId        string
+	Container     string
+	ExitCode      int64
+	Reason        string
+	StoppedReason string
+}
+
+func (e *TaskExitError) Error() string {
+
+	msg := fmt.Sprintf("Container %s in task %s exited with status %d", e.Container, e.TaskId, e.ExitCode)
+
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s (%s)", msg, e.Reason)
+	}
+
+	if e.StoppedReason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.StoppedReason)
+	}
+
+	return msg
+}
+
+// TaskNotFoundError is returned by DescribeTask when ECS doesn't know about a task, either
+// because it never existed or because it stopped long enough ago to have been forgotten.
+type TaskNotFoundError struct {
+	TaskId string
+}
+
+func (e *TaskNotFoundError) Error() string {
+	return fmt.Sprintf("Task %s not found", e.TaskId)
+}
+
+// NotProcessTaskError is returned when asked to act on a task that wasn't launched by
+// LaunchProcessTask (see IsProcessTask).
+type NotProcessTaskError struct {
+	TaskId string
+}
+
+func (e *NotProcessTaskError) Error() string {
+	return fmt.Sprintf("Task %s was not launched by iiif-process-ecs", e.TaskId)
+}
+
+func DescribeTask(svc ECSService, cluster string, task_id string) (*aws_ecs.Task, error) {
+
+	input := &aws_ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks: []*string{
+			aws.String(task_id),
+		},
+		Include: []*string{
+			aws.String(aws_ecs.TaskFieldTags),
+		},
+	}
+
+	rsp, err := svc.DescribeTasks(input)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rsp.Tasks) == 0 {
+
+		if len(rsp.Failures) > 0 {
+
+			if aws.StringValue(rsp.Failures[0].Reason) == "MISSING" {
+				return nil, &TaskNotFoundError{TaskId: task_id}
+			}
+
+			msg := fmt.Sprintf("Failed to describe task %s: %s", task_id, aws.StringValue(rsp.Failures[0].Reason))
+			return nil, errors.New(msg)
+		}
+
+		msg := fmt.Sprintf("Failed to describe task %s", task_id)
+		return nil, errors.New(msg)
+	}
+
+	return rsp.Tasks[0], nil
+}
+
+// NewProcessTaskResponse returns a ProcessTaskResponse for a task that was launched by
+// LaunchProcessTask, recovering the URIs it is processing from the command passed to
+// container.
+func NewProcessTaskResponse(task *aws_ecs.Task, container string) *ProcessTaskResponse {
+
+	t := &ProcessTaskResponse{
+		JobId:  TaskJobId(task),
+		TaskId: aws.StringValue(task.TaskArn),
+		URIs:   make([]uri.URI, 0),
+	}
+
+	if task.Overrides != nil {
+
+		for _, o := range task.Overrides.ContainerOverrides {
+
+			if aws.StringValue(o.Name) != container {
+				continue
+			}
+
+			for i, arg := range o.Command {
+
+				if aws.StringValue(arg) != "-uri" || i+1 >= len(o.Command) {
+					continue
+				}
+
+				u, err := ParseURI(aws.StringValue(o.Command[i+1]))
+
+				if err != nil {
+					continue
+				}
+
+				t.URIs = append(t.URIs, u)
+			}
+		}
+	}
+
+	t.setTask(task)
+	return t
+}
+
+// TaskJobId returns the job ID for task from its JOB_ID_TAG tag or, failing that, its startedBy
+// property. Tasks started by ECS services have a startedBy property of "ecs-svc/..." which is
+// ignored. Note that tags are only included in the output of DescribeTasks if they are asked
+// for.
+func TaskJobId(task *aws_ecs.Task) string {
+
+	for _, t := range task.Tags {
+
+		if aws.StringValue(t.Key) == JOB_ID_TAG {
+			return aws.StringValue(t.Value)
+		}
+	}
+
+	started_by := aws.StringValue(task.StartedBy)
+
+	if strings.HasPrefix(started_by, "ecs-svc/") {
+		return ""
+	}
+
+	return started_by
+}
+
+// TaskIdFromArn returns the ID of a task (the last element of its ARN).
+func TaskIdFromArn(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+func (t *ProcessTaskResponse) setTask(task *aws_ecs.Task) {
+
+	t.Status = aws.StringValue(task.LastStatus)
+	t.StoppedReason = aws.StringValue(task.StoppedReason)
+
+	if task.CreatedAt != nil {
+		t.CreatedAt = aws.Time(*task.CreatedAt)
+	}
+
+	if task.StartedAt != nil {
+		t.StartedAt = aws.Time(*task.StartedAt)
+	}
+
+	if task.StoppedAt != nil {
+		t.StoppedAt = aws.Time(*task.StoppedAt)
+	}
+
+	containers := make([]*ContainerStatus, len(task.Containers))
+
+	for i, c := range task.Containers {
+
+		containers[i] = &ContainerStatus{
+			Name:     aws.StringValue(c.Name),
+			ExitCode: c.ExitCode,
+			Reason:   aws.StringValue(c.Reason),
+		}
+	}
+
+	t.Containers = containers
+}
+
+func (t *ProcessTaskResponse) Duration() time.Duration {
+
+	if t.StartedAt == nil || t.StoppedAt == nil {
+		return 0
+	}
+
+	return t.StoppedAt.Sub(*t.StartedAt)
+}
+
+// ExitError returns a *TaskExitError for the first container in a stopped task that did
+// not exit cleanly, or nil if they all did (or the task has not stopped yet).
+func (t *ProcessTaskResponse) ExitError() error {
+
+	if t.Status != TASK_STATUS_STOPPED {
+		return nil
+	}
+
+	for _, c := range t.Containers {
+
+		exit_code := int64(-1)
+
+		if c.ExitCode != nil {
+			exit_code = *c.ExitCode
+		}
+
+		if exit_code == 0 {
+			continue
+		}
+
+		err := &TaskExitError{
+			TaskId:        t.TaskId,
+			Container:     c.Name,
+			ExitCode:      exit_code,
+			Reason:        c.Reason,
+			StoppedReason: t.StoppedReason,
+		}
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,435 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"log"
+	"strings"
+	"time"
+)
+
+const EVENT_SOURCE_ECS string = "aws.ecs"
+
+const EVENT_TYPE_TASK_STATE_CHANGE string = "ECS Task State Change"
+
+// TaskStateChangeDetail is the part of the detail of an EventBridge "ECS Task State Change"
+// event that we need to finalize a task.
+// https://docs.aws.amazon.com/AmazonECS/latest/developerguide/ecs_cwe_events.html
+type TaskStateChangeDetail struct {
+	TaskArn           string                        `json:"taskArn"`
+	ClusterArn        string                        `json:"clusterArn"`
+	TaskDefinitionArn string                        `json:"taskDefinitionArn"`
+	StartedBy         string                        `json:"startedBy"`
+	LastStatus        string                        `json:"lastStatus"`
+	DesiredStatus     string                        `json:"desiredStatus"`
+	StoppedReason     string                        `json:"stoppedReason"`
+	StopCode          string                        `json:"stopCode"`
+	CreatedAt         *time.Time                    `json:"createdAt"`
+	StartedAt         *time.Time                    `json:"startedAt"`
+	StoppedAt         *time.Time                    `json:"stoppedAt"`
+	Containers        []*TaskStateChangeContainer   `json:"containers"`
+	Overrides         *TaskStateChangeTaskOverrides `json:"overrides"`
+}
+
+type TaskStateChangeContainer struct {
+	Name       string `json:"name"`
+	LastStatus string `json:"lastStatus"`
+	ExitCode   *int64 `json:"exitCode"`
+	Reason     string `json:"reason"`
+}
+
+type TaskStateChangeTaskOverrides struct {
+	ContainerOverrides []*TaskStateChangeContainerOverride `json:"containerOverrides"`
+}
+
+type TaskStateChangeContainerOverride struct {
+	Name        string                         `json:"name"`
+	Command     []string                       `json:"command"`
+	Environment []*TaskStateChangeKeyValuePair `json:"environment"`
+}
+
+type TaskStateChangeKeyValuePair struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Task returns the equivalent of d as returned by DescribeTasks.
+func (d *TaskStateChangeDetail) Task() *aws_ecs.Task {
+
+	task := &aws_ecs.Task{
+		TaskArn:           aws.String(d.TaskArn),
+		ClusterArn:        aws.String(d.ClusterArn),
+		TaskDefinitionArn: aws.String(d.TaskDefinitionArn),
+		LastStatus:        aws.String(d.LastStatus),
+		DesiredStatus:     aws.String(d.DesiredStatus),
+		CreatedAt:         d.CreatedAt,
+		StartedAt:         d.StartedAt,
+		StoppedAt:         d.StoppedAt,
+		Containers:        make([]*aws_ecs.Container, len(d.Containers)),
+	}
+
+	if d.StartedBy != "" {
+		task.StartedBy = aws.String(d.StartedBy)
+	}
+
+	if d.StoppedReason != "" {
+		task.StoppedReason = aws.String(d.StoppedReason)
+	}
+
+	if d.StopCode != "" {
+		task.StopCode = aws.String(d.StopCode)
+	}
+
+	for i, c := range d.Containers {
+
+		task.Containers[i] = &aws_ecs.Container{
+			Name:       aws.String(c.Name),
+			LastStatus: aws.String(c.LastStatus),
+			ExitCode:   c.ExitCode,
+		}
+
+		if c.Reason != "" {
+			task.Containers[i].Reason = aws.String(c.Reason)
+		}
+	}
+
+	if d.Overrides != nil {
+
+		overrides := &aws_ecs.TaskOverride{
+			ContainerOverrides: make([]*aws_ecs.ContainerOverride, len(d.Overrides.ContainerOverrides)),
+		}
+
+		for i, o := range d.Overrides.ContainerOverrides {
+
+			overrides.ContainerOverrides[i] = &aws_ecs.ContainerOverride{
+				Name:    aws.String(o.Name),
+				Command: aws.StringSlice(o.Command),
+			}
+
+			for _, kv := range o.Environment {
+
+				env := &aws_ecs.KeyValuePair{
+					Name:  aws.String(kv.Name),
+					Value: aws.String(kv.Value),
+				}
+
+				overrides.ContainerOverrides[i].Environment = append(overrides.ContainerOverrides[i].Environment, env)
+			}
+		}
+
+		task.Overrides = overrides
+	}
+
+	return task
+}
+
+// IsProcessTask returns true if d describes a task in cluster (which may be a name or an
+// ARN, or empty to match any cluster) that was launched by LaunchProcessTask to run
+// iiif-process in container.
+func (d *TaskStateChangeDetail) IsProcessTask(cluster string, container string) bool {
+
+	if cluster != "" && d.ClusterArn != cluster && !strings.HasSuffix(d.ClusterArn, ":cluster/"+cluster) {
+		return false
+	}
+
+	if d.Overrides == nil {
+		return false
+	}
+
+	for _, o := range d.Overrides.ContainerOverrides {
+
+		if o.Name == container && isProcessCommand(o.Command) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// TaskStateChangeLambdaHandlerFunc returns a Lambda handler for EventBridge "ECS Task State
+// Change" events. When a task launched by LaunchProcessTask stops, its final status, exit
+// code and results are written to opts.Store (if there is one) and then sent to each of
+// notifiers. Events for other tasks, or for tasks that haven't stopped yet, are ignored.
+// Tasks are matched to jobs using their startedBy property or JOB_ID_TAG tag.
+func TaskStateChangeLambdaHandlerFunc(opts *ProcessTaskOptions, notifiers []Notifier) func(ctx context.Context, ev aws_events.CloudWatchEvent) (*ProcessTaskResponse, error) {
+
+	handler := func(ctx context.Context, ev aws_events.CloudWatchEvent) (*ProcessTaskResponse, error) {
+
+		detail, err := stoppedProcessTask(opts, ev)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if detail == nil {
+			return nil, nil
+		}
+
+		l, err := NewProcessTaskLauncherWithDSN(opts.DSN)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return l.finalizeTask(ctx, opts, detail.Task(), notifiers)
+	}
+
+	return handler
+}
+
+// stoppedProcessTask returns the detail of ev if it describes a task launched by LaunchProcessTask
+// that has stopped or nil if it should be ignored.
+func stoppedProcessTask(opts *ProcessTaskOptions, ev aws_events.CloudWatchEvent) (*TaskStateChangeDetail, error) {
+
+	if ev.Source != EVENT_SOURCE_ECS || ev.DetailType != EVENT_TYPE_TASK_STATE_CHANGE {
+		msg := fmt.Sprintf("Unsupported event %s (%s)", ev.DetailType, ev.Source)
+		return nil, errors.New(msg)
+	}
+
+	var detail *TaskStateChangeDetail
+
+	err := json.Unmarshal(ev.Detail, &detail)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if detail == nil {
+		return nil, errors.New("Event has no detail")
+	}
+
+	if detail.LastStatus != TASK_STATUS_STOPPED {
+		return nil, nil
+	}
+
+	if !detail.IsProcessTask(opts.Cluster, opts.Container) {
+		log.Printf("Skipping task %s, not an iiif-process task\n", detail.TaskArn)
+		return nil, nil
+	}
+
+	return detail, nil
+}
+
+// finalizeTask records the outcome of a stopped task and sends it to notifiers.
+func (l *ProcessTaskLauncher) finalizeTask(ctx context.Context, opts *ProcessTaskOptions, task *aws_ecs.Task, notifiers []Notifier) (*ProcessTaskResponse, error) {
+
+	task_rsp := NewProcessTaskResponse(task, opts.Container)
+
+	if task_rsp.JobId == "" {
+		task_rsp.JobId = l.jobIdForTask(task)
+	}
+
+	if l.logs != nil {
+
+		results, err := l.readProcessResults(task, opts.Container)
+
+		if err != nil {
+			log.Printf("[WARNING] Unable to read output for task %s: %s\n", task_rsp.TaskId, err)
+		} else {
+			task_rsp.Results = results
+		}
+	}
+
+	// tasks launched with a manifest don't have any URIs in their command so read them
+	// back from the manifest, which is removed once the task has been finalized
+
+	manifest_source, manifest := TaskManifest(task, opts.Container)
+
+	if manifest != "" && (opts.Staging == nil || !opts.Staging.IsStaged(manifest_source, manifest)) {
+		log.Printf("[WARNING] Unable to read manifest %s for task %s, not in the staging bucket\n", manifest, task_rsp.TaskId)
+		manifest = ""
+	}
+
+	if manifest != "" && len(task_rsp.URIs) == 0 {
+
+		str_uris, err := opts.Staging.ReadManifest(ctx, manifest)
+
+		if err != nil {
+			log.Printf("[WARNING] Unable to read manifest %s for task %s, leaving it in place: %s\n", manifest, task_rsp.TaskId, err)
+			manifest = ""
+		}
+
+		for _, str_uri := range str_uris {
+
+			u, err := ParseURI(str_uri)
+
+			if err != nil {
+				log.Printf("[WARNING] Invalid URI in manifest %s for task %s: %s\n", manifest, task_rsp.TaskId, err)
+				continue
+			}
+
+			task_rsp.URIs = append(task_rsp.URIs, u)
+		}
+	}
+
+	task_err := task_rsp.ExitError()
+
+	task_opts := taskOptions(opts, task)
+	task_opts.JobId = task_rsp.JobId
+
+	l.recordTask(ctx, task_opts, task_rsp, task_err)
+
+	l.cleanupStagedInstructions(ctx, task_opts, task_rsp.TaskId)
+
+	result := &JobResult{
+		JobId: task_rsp.JobId,
+		Task:  task_rsp,
+	}
+
+	if task_err != nil {
+		result.Error = task_err.Error()
+	}
+
+	enc_result, err := json.Marshal(result.Redacted())
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println(string(enc_result))
+
+	// notifications are sent at least once; if any of them fail an error is returned
+	// so that the event will be retried, which means the others will be sent again
+
+	failed := 0
+
+	for _, n := range notifiers {
+
+		err := n.Notify(ctx, result)
+
+		if err != nil {
+			log.Printf("[WARNING] Failed to send notification for task %s: %s\n", task_rsp.TaskId, err)
+			failed += 1
+		}
+	}
+
+	if failed > 0 {
+		msg := fmt.Sprintf("Failed to send %d of %d notifications for task %s", failed, len(notifiers), task_rsp.TaskId)
+		return task_rsp, errors.New(msg)
+	}
+
+	removeStaged(ctx, opts, manifest)
+
+	return task_rsp, nil
+}
+
+// cleanupStagedInstructions removes the instructions that a task in task_opts.JobId was launched
+// with if they were staged by task_opts.Staging, and it is configured to clean up, and none of the
+// other tasks in the job are still running.
+func (l *ProcessTaskLauncher) cleanupStagedInstructions(ctx context.Context, task_opts *ProcessTaskOptions, task_id string) {
+
+	if task_opts.Staging == nil || !task_opts.Staging.Cleanup {
+		return
+	}
+
+	if !task_opts.Staging.IsStaged(task_opts.InstructionsSource, task_opts.InstructionsName) {
+		return
+	}
+
+	tasks, err := l.ListProcessTasks(ctx, task_opts, task_opts.JobId)
+
+	if err != nil {
+		log.Printf("[WARNING] Unable to list tasks for job %s, leaving staged instructions %s in place: %s\n", task_opts.JobId, task_opts.InstructionsName, err)
+		return
+	}
+
+	for _, t := range tasks {
+
+		if t.Task.TaskId == task_id || t.Task.Status == TASK_STATUS_STOPPED {
+			continue
+		}
+
+		log.Printf("Leaving staged instructions %s in place because task %s is still running\n", task_opts.InstructionsName, t.Task.TaskId)
+		return
+	}
+
+	cleanupInstructions(ctx, task_opts, task_opts.InstructionsName)
+}
+
+// taskOptions returns a copy of opts updated with the task definition, IIIF config and
+// instructions and report settings that task was actually launched with, as opposed to the
+// defaults in opts.
+func taskOptions(opts *ProcessTaskOptions, task *aws_ecs.Task) *ProcessTaskOptions {
+
+	task_opts := *opts
+	task_opts.Task = aws.StringValue(task.TaskDefinitionArn)
+	task_opts.Config = ""
+	task_opts.ConfigSource = ""
+	task_opts.ConfigName = ""
+	task_opts.Instructions = ""
+	task_opts.InstructionsSource = ""
+	task_opts.InstructionsName = ""
+	task_opts.Report = false
+	task_opts.ReportName = ""
+
+	if task.Overrides == nil {
+		return &task_opts
+	}
+
+	for _, o := range task.Overrides.ContainerOverrides {
+
+		if aws.StringValue(o.Name) != opts.Container {
+			continue
+		}
+
+		cmd := aws.StringValueSlice(o.Command)
+
+		for i, arg := range cmd {
+
+			if arg == "-report" {
+				task_opts.Report = true
+				continue
+			}
+
+			if i+1 >= len(cmd) {
+				continue
+			}
+
+			switch arg {
+			case "-config":
+				task_opts.Config = cmd[i+1]
+			case "-config-source":
+				task_opts.ConfigSource = cmd[i+1]
+			case "-config-name":
+				task_opts.ConfigName = cmd[i+1]
+			case "-instructions":
+				task_opts.Instructions = cmd[i+1]
+			case "-instructions-source":
+				task_opts.InstructionsSource = cmd[i+1]
+			case "-instructions-name":
+				task_opts.InstructionsName = cmd[i+1]
+			case "-report-name":
+				task_opts.ReportName = cmd[i+1]
+			}
+		}
+	}
+
+	return &task_opts
+}
+
+// jobIdForTask returns the job ID for task (see TaskJobId), asking ECS for the task's tags
+// if necessary since they aren't included in task state change events.
+func (l *ProcessTaskLauncher) jobIdForTask(task *aws_ecs.Task) string {
+
+	job_id := TaskJobId(task)
+
+	if job_id != "" {
+		return job_id
+	}
+
+	// this will fail if the task stopped long enough ago for ECS to have forgotten
+	// about it
+
+	described, err := DescribeTask(l.service, aws.StringValue(task.ClusterArn), aws.StringValue(task.TaskArn))
+
+	if err != nil {
+		return ""
+	}
+
+	return TaskJobId(described)
+}
@@ -0,0 +1,497 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	aws_events "github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// fakeNotifier records the results it is sent and, if Error is set, fails.
+type fakeNotifier struct {
+	Results []*JobResult
+	Error   error
+	mu      *sync.Mutex
+}
+
+func newFakeNotifier() *fakeNotifier {
+
+	n := fakeNotifier{
+		Results: make([]*JobResult, 0),
+		mu:      new(sync.Mutex),
+	}
+
+	return &n
+}
+
+func (n *fakeNotifier) Notify(ctx context.Context, result *JobResult) error {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.Error != nil {
+		return n.Error
+	}
+
+	n.Results = append(n.Results, result)
+	return nil
+}
+
+// newTestTaskStateChangeEvent returns the "ECS Task State Change" event that EventBridge would
+// send for task. Like the real thing it doesn't include the task's tags.
+func newTestTaskStateChangeEvent(t *testing.T, task *aws_ecs.Task) aws_events.CloudWatchEvent {
+
+	detail := &TaskStateChangeDetail{
+		TaskArn:           aws.StringValue(task.TaskArn),
+		ClusterArn:        aws.StringValue(task.ClusterArn),
+		TaskDefinitionArn: aws.StringValue(task.TaskDefinitionArn),
+		StartedBy:         aws.StringValue(task.StartedBy),
+		LastStatus:        aws.StringValue(task.LastStatus),
+		DesiredStatus:     aws.StringValue(task.DesiredStatus),
+		StoppedReason:     aws.StringValue(task.StoppedReason),
+		CreatedAt:         task.CreatedAt,
+		StartedAt:         task.StartedAt,
+		StoppedAt:         task.StoppedAt,
+		Containers:        make([]*TaskStateChangeContainer, len(task.Containers)),
+	}
+
+	for i, c := range task.Containers {
+
+		detail.Containers[i] = &TaskStateChangeContainer{
+			Name:       aws.StringValue(c.Name),
+			LastStatus: aws.StringValue(c.LastStatus),
+			ExitCode:   c.ExitCode,
+		}
+	}
+
+	if task.Overrides != nil {
+
+		detail.Overrides = &TaskStateChangeTaskOverrides{
+			ContainerOverrides: make([]*TaskStateChangeContainerOverride, len(task.Overrides.ContainerOverrides)),
+		}
+
+		for i, o := range task.Overrides.ContainerOverrides {
+
+			override := &TaskStateChangeContainerOverride{
+				Name:    aws.StringValue(o.Name),
+				Command: aws.StringValueSlice(o.Command),
+			}
+
+			for _, kv := range o.Environment {
+
+				env := &TaskStateChangeKeyValuePair{
+					Name:  aws.StringValue(kv.Name),
+					Value: aws.StringValue(kv.Value),
+				}
+
+				override.Environment = append(override.Environment, env)
+			}
+
+			detail.Overrides.ContainerOverrides[i] = override
+		}
+	}
+
+	enc_detail, err := json.Marshal(detail)
+
+	if err != nil {
+		t.Fatalf("Failed to encode event detail, %s", err)
+	}
+
+	ev := aws_events.CloudWatchEvent{
+		Source:     EVENT_SOURCE_ECS,
+		DetailType: EVENT_TYPE_TASK_STATE_CHANGE,
+		Detail:     enc_detail,
+	}
+
+	return ev
+}
+
+// stopTestTask waits for task_id to stop and returns its "ECS Task State Change" event.
+func stopTestTask(t *testing.T, svc *FakeECSService, cluster string, task_id string) aws_events.CloudWatchEvent {
+
+	input := &aws_ecs.DescribeTasksInput{
+		Cluster: aws.String(cluster),
+		Tasks:   []*string{aws.String(task_id)},
+	}
+
+	err := svc.WaitUntilTasksStopped(input)
+
+	if err != nil {
+		t.Fatalf("Failed to wait for task, %s", err)
+	}
+
+	task, err := DescribeTask(svc, cluster, task_id)
+
+	if err != nil {
+		t.Fatalf("Failed to describe task, %s", err)
+	}
+
+	return newTestTaskStateChangeEvent(t, task)
+}
+
+func TestStoppedProcessTask(t *testing.T) {
+
+	opts := newTestProcessTaskOptions(t, "file:///zuber.jpg")
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	rsp, err := l.LaunchProcessTask(context.Background(), opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	task, err := DescribeTask(svc, opts.Cluster, rsp.TaskId)
+
+	if err != nil {
+		t.Fatalf("Failed to describe task, %s", err)
+	}
+
+	running := newTestTaskStateChangeEvent(t, task)
+	stopped := stopTestTask(t, svc, opts.Cluster, rsp.TaskId)
+
+	other_cluster := newTestProcessTaskOptions(t)
+	other_cluster.Cluster = "other"
+
+	other_container := newTestProcessTaskOptions(t)
+	other_container.Container = "other"
+
+	unsupported := stopped
+	unsupported.DetailType = "ECS Container Instance State Change"
+
+	// a task running something other than iiif-process in the same container
+
+	var detail *TaskStateChangeDetail
+
+	err = json.Unmarshal(stopped.Detail, &detail)
+
+	if err != nil {
+		t.Fatalf("Failed to decode event detail, %s", err)
+	}
+
+	detail.Overrides.ContainerOverrides[0].Command = []string{"/bin/sh", "-c", "true"}
+
+	enc_detail, err := json.Marshal(detail)
+
+	if err != nil {
+		t.Fatalf("Failed to encode event detail, %s", err)
+	}
+
+	other_command := stopped
+	other_command.Detail = enc_detail
+
+	tests := []struct {
+		name     string
+		opts     *ProcessTaskOptions
+		ev       aws_events.CloudWatchEvent
+		expected bool
+		fails    bool
+	}{
+		{"stopped", opts, stopped, true, false},
+		{"running", opts, running, false, false},
+		{"other cluster", other_cluster, stopped, false, false},
+		{"other container", other_container, stopped, false, false},
+		{"other command", opts, other_command, false, false},
+		{"unsupported event", opts, unsupported, false, true},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			detail, err := stoppedProcessTask(tt.opts, tt.ev)
+
+			if tt.fails {
+
+				if err == nil {
+					t.Fatal("Expected event to be rejected")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Failed to read event, %s", err)
+			}
+
+			if tt.expected && (detail == nil || detail.TaskArn != rsp.TaskId) {
+				t.Fatalf("Expected detail for task %s, got %v", rsp.TaskId, detail)
+			}
+
+			if !tt.expected && detail != nil {
+				t.Fatalf("Expected event to be ignored, got detail for task %s", detail.TaskArn)
+			}
+		})
+	}
+}
+
+// newTestFinalizeOptions returns ProcessTaskOptions for a job whose URIs are passed to its
+// tasks by manifest, with an instructions document that is staged (and cleaned up) and a
+// file job store.
+func newTestFinalizeOptions(t *testing.T, dir string) (*ProcessTaskOptions, *fakeS3Service) {
+
+	s3_svc := newFakeS3Service()
+
+	staging, err := NewStaging(s3_svc, "s3://staging", DEFAULT_STAGING_PREFIX)
+
+	if err != nil {
+		t.Fatalf("Failed to create staging, %s", err)
+	}
+
+	staging.Cleanup = true
+
+	store, err := NewFileJobStore(filepath.Join(dir, "jobs.json"))
+
+	if err != nil {
+		t.Fatalf("Failed to create job store, %s", err)
+	}
+
+	opts := newTestProcessTaskOptions(t)
+
+	for _, str_uri := range []string{"file:///my%20photo.jpg", "file:///zuber.jpg"} {
+
+		u, err := ParseURI(str_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to parse %s, %s", str_uri, err)
+		}
+
+		opts.URIs = append(opts.URIs, u)
+	}
+
+	opts.JobId = "iiif-test"
+	opts.Manifest = true
+	opts.Staging = staging
+	opts.InstructionsDocument = []byte(`{"o": {"size": "full", "format": "jpg"}}`)
+	opts.Store = store
+
+	return opts, s3_svc
+}
+
+func TestFinalizeTask(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "jobs")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts, s3_svc := newTestFinalizeOptions(t, dir)
+
+	ctx := context.Background()
+
+	svc := NewFakeECSService()
+	svc.ExitCodes = []int64{0, 1}
+
+	l := NewProcessTaskLauncher(svc, nil)
+
+	// two tasks in the same job share the same staged instructions
+
+	rsp, err := l.LaunchProcessTask(ctx, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	other_opts := *opts
+	other_opts.URIs = opts.URIs[1:]
+
+	other_rsp, err := l.LaunchProcessTask(ctx, &other_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	staged := s3_svc.Keys()
+
+	// one set of instructions and a manifest for each task
+
+	if len(staged) != 3 {
+		t.Fatalf("Expected 3 staged files, got %v", staged)
+	}
+
+	ev := stopTestTask(t, svc, opts.Cluster, rsp.TaskId)
+
+	// the task state change handler is configured with the defaults, not the job's settings
+
+	defaults := *opts
+	defaults.JobId = ""
+	defaults.Manifest = false
+	defaults.InstructionsDocument = nil
+
+	detail, err := stoppedProcessTask(&defaults, ev)
+
+	if err != nil || detail == nil {
+		t.Fatalf("Expected event for task %s, %v", rsp.TaskId, err)
+	}
+
+	n := newFakeNotifier()
+
+	final_rsp, err := l.finalizeTask(ctx, &defaults, detail.Task(), []Notifier{n})
+
+	if err != nil {
+		t.Fatalf("Failed to finalize task, %s", err)
+	}
+
+	// URIs are read back from the manifest
+
+	if final_rsp.JobId != opts.JobId {
+		t.Fatalf("Expected job ID %s, got '%s'", opts.JobId, final_rsp.JobId)
+	}
+
+	if len(final_rsp.URIs) != 2 || final_rsp.URIs[0].String() != "file:///my%20photo.jpg" || final_rsp.URIs[1].String() != "file:///zuber.jpg" {
+		t.Fatalf("Unexpected URIs %v", final_rsp.URIs)
+	}
+
+	records, err := opts.Store.JobRecordsForJobId(ctx, opts.JobId)
+
+	if err != nil {
+		t.Fatalf("Failed to read records, %s", err)
+	}
+
+	stopped := 0
+
+	for _, r := range records {
+
+		if r.TaskId != rsp.TaskId {
+			continue
+		}
+
+		if r.Status != TASK_STATUS_STOPPED || r.ExitCode == nil || *r.ExitCode != 0 {
+			t.Fatalf("Expected record for %s to be stopped, got %v", r.URI, r)
+		}
+
+		stopped += 1
+	}
+
+	if stopped != 2 {
+		t.Fatalf("Expected 2 stopped records, got %d", stopped)
+	}
+
+	if len(n.Results) != 1 || n.Results[0].JobId != opts.JobId || n.Results[0].Task.TaskId != rsp.TaskId || n.Results[0].Error != "" {
+		t.Fatalf("Unexpected notifications %v", n.Results)
+	}
+
+	// the task's manifest is removed but the other task is still running so the instructions
+	// are left in place
+
+	staged = s3_svc.Keys()
+
+	instructions := opts.Staging.InstructionsName(opts.JobId, opts.InstructionsDocument)
+
+	if len(staged) != 2 || (staged[0] != instructions && staged[1] != instructions) {
+		t.Fatalf("Expected the instructions and the other task's manifest, got %v", staged)
+	}
+
+	// once the other task has stopped (and failed) everything is removed
+
+	ev = stopTestTask(t, svc, opts.Cluster, other_rsp.TaskId)
+
+	detail, err = stoppedProcessTask(&defaults, ev)
+
+	if err != nil || detail == nil {
+		t.Fatalf("Expected event for task %s, %v", other_rsp.TaskId, err)
+	}
+
+	_, err = l.finalizeTask(ctx, &defaults, detail.Task(), []Notifier{n})
+
+	if err != nil {
+		t.Fatalf("Failed to finalize task, %s", err)
+	}
+
+	if len(n.Results) != 2 || n.Results[1].Task.TaskId != other_rsp.TaskId || n.Results[1].Error == "" {
+		t.Fatalf("Expected failure notification for task %s, got %v", other_rsp.TaskId, n.Results)
+	}
+
+	staged = s3_svc.Keys()
+
+	if len(staged) != 0 {
+		t.Fatalf("Expected staged files to be removed, got %v", staged)
+	}
+}
+
+func TestFinalizeTaskNotificationFailed(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "jobs")
+
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory, %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	opts, s3_svc := newTestFinalizeOptions(t, dir)
+	opts.Staging.Cleanup = false
+
+	ctx := context.Background()
+
+	svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(svc, nil)
+
+	rsp, err := l.LaunchProcessTask(ctx, opts)
+
+	if err != nil {
+		t.Fatalf("Failed to launch task, %s", err)
+	}
+
+	ev := stopTestTask(t, svc, opts.Cluster, rsp.TaskId)
+
+	detail, err := stoppedProcessTask(opts, ev)
+
+	if err != nil || detail == nil {
+		t.Fatalf("Expected event for task %s, %v", rsp.TaskId, err)
+	}
+
+	ok := newFakeNotifier()
+
+	failing := newFakeNotifier()
+	failing.Error = errors.New("Service unavailable")
+
+	_, err = l.finalizeTask(ctx, opts, detail.Task(), []Notifier{ok, failing})
+
+	if err == nil {
+		t.Fatal("Expected failed notification to be reported")
+	}
+
+	if len(ok.Results) != 1 {
+		t.Fatalf("Expected other notifiers to be sent, got %d results", len(ok.Results))
+	}
+
+	// the manifest is left in place so that the event can be retried
+
+	staged := s3_svc.Keys()
+
+	if len(staged) != 2 {
+		t.Fatalf("Expected staged files to be left in place, got %v", staged)
+	}
+
+	failing.Error = nil
+
+	final_rsp, err := l.finalizeTask(ctx, opts, detail.Task(), []Notifier{ok, failing})
+
+	if err != nil {
+		t.Fatalf("Failed to finalize task, %s", err)
+	}
+
+	if len(final_rsp.URIs) != 2 || len(failing.Results) != 1 {
+		t.Fatalf("Expected retry to read the manifest and notify, got %v", final_rsp.URIs)
+	}
+
+	// Cleanup is false so only the manifest is removed
+
+	staged = s3_svc.Keys()
+
+	if len(staged) != 1 || staged[0] != opts.Staging.InstructionsName(opts.JobId, opts.InstructionsDocument) {
+		t.Fatalf("Expected only the instructions to be left in place, got %v", staged)
+	}
+}
@@ -0,0 +1,143 @@
+package ecs
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-iiif/go-iiif-uri"
+	"mime"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// parsedURI is a uri.URI whose String method returns the string it was parsed from. The
+// go-iiif-uri drivers don't always return that: file URIs return their decoded origin, which
+// can't be parsed again for keys containing characters like "?", "#" or "%".
+type parsedURI struct {
+	uri.URI
+	str_uri string
+}
+
+func (u *parsedURI) String() string {
+	return u.str_uri
+}
+
+// ParseURI parses str_uri (see uri.NewURI) such that its String method returns str_uri as
+// written. This is how URIs are parsed everywhere they are read back, from task commands,
+// manifests, task responses or the command line, so that they can be compared with (and used
+// to look up records for) the URIs that tasks were launched with.
+func ParseURI(str_uri string) (uri.URI, error) {
+
+	u, err := uri.NewURI(str_uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	p := parsedURI{
+		URI:     u,
+		str_uri: str_uri,
+	}
+
+	return &p, nil
+}
+
+// REDACTED is the value that secrets in URIs are replaced with by RedactURI.
+const REDACTED string = "REDACTED"
+
+// RedactURI returns str_uri with the values of any "secret" or "secret_o" query parameters (as
+// used by idsecret URIs) replaced by REDACTED, and is otherwise as written. It is used when
+// logging URIs and sending notifications about them.
+func RedactURI(str_uri string) string {
+
+	idx := strings.Index(str_uri, "?")
+
+	if idx == -1 {
+		return str_uri
+	}
+
+	params := strings.Split(str_uri[idx+1:], "&")
+
+	for i, p := range params {
+
+		k := strings.SplitN(p, "=", 2)[0]
+
+		key, err := url.QueryUnescape(k)
+
+		if err != nil {
+			key = k
+		}
+
+		if key == "secret" || key == "secret_o" {
+			params[i] = k + "=" + REDACTED
+		}
+	}
+
+	return str_uri[:idx+1] + strings.Join(params, "&")
+}
+
+// redactURI returns a copy of u whose String method returns its redacted form (see RedactURI).
+func redactURI(u uri.URI) uri.URI {
+
+	str_uri := u.String()
+	redacted := RedactURI(str_uri)
+
+	if redacted == str_uri {
+		return u
+	}
+
+	if p, ok := u.(*parsedURI); ok {
+		u = p.URI
+	}
+
+	r := parsedURI{
+		URI:     u,
+		str_uri: redacted,
+	}
+
+	return &r
+}
+
+// ValidateURI ensures that im can be processed by iiif-process, meaning that it uses one of the
+// go-iiif-uri drivers and its origin is an image. For file, rewrite and idsecret URIs the origin
+// is the source image while the target is where derivatives are written, which may not have an
+// extension at all (for example "file:///zuber.jpg?target=zuber") or, for idsecret URIs, can't
+// be determined until iiif-process assigns each derivative a label and format.
+func ValidateURI(im uri.URI) error {
+
+	origin, err := uriOrigin(im)
+
+	if err != nil {
+		return err
+	}
+
+	origin_type := mime.TypeByExtension(filepath.Ext(origin))
+
+	if !strings.HasPrefix(origin_type, "image/") {
+		msg := fmt.Sprintf("%s has unknown or invalid mime-type '%s'", im.String(), origin_type)
+		return errors.New(msg)
+	}
+
+	return nil
+}
+
+// uriOrigin returns the path of the source image for im.
+func uriOrigin(im uri.URI) (string, error) {
+
+	switch im.Driver() {
+	case uri.FileDriverName, uri.RewriteDriverName, uri.IdSecretDriverName:
+		// pass
+	default:
+		msg := fmt.Sprintf("%s has unsupported driver '%s'", im.String(), im.Driver())
+		return "", errors.New(msg)
+	}
+
+	origin := im.Origin()
+
+	if origin == "" {
+		msg := fmt.Sprintf("%s has no origin", im.String())
+		return "", errors.New(msg)
+	}
+
+	return origin, nil
+}
@@ -0,0 +1,229 @@
+package ecs
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/go-iiif/go-iiif-uri"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// unsupportedURI is a uri.URI for a driver that iiif-process doesn't know about.
+type unsupportedURI struct {
+	uri.URI
+}
+
+func (u *unsupportedURI) Driver() string {
+	return "example"
+}
+
+func (u *unsupportedURI) String() string {
+	return "example:///zuber.jpg"
+}
+
+func (u *unsupportedURI) Origin() string {
+	return "zuber.jpg"
+}
+
+func (u *unsupportedURI) Target(*url.Values) (string, error) {
+	return "zuber.jpg", nil
+}
+
+func TestValidateURI(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		uri   string
+		valid bool
+	}{
+		{"file", "file:///zuber.jpg", true},
+		{"file with path", "file:///images/2019/zuber.png", true},
+		{"file with target", "file:///zuber.jpg?target=zuber", true},
+		{"file with target extension", "file:///zuber.jpg?target=zuber.txt", true},
+		{"file not an image", "file:///zuber.txt", false},
+		{"file no extension", "file:///zuber", false},
+		{"file not an image with image target", "file:///zuber.pdf?target=zuber.jpg", false},
+		{"rewrite", "rewrite:///zuber.jpg?target=avocado/toast", true},
+		{"rewrite image target", "rewrite:///zuber.gif?target=avocado.jpg", true},
+		{"rewrite not an image", "rewrite:///zuber.txt?target=avocado.jpg", false},
+		{"idsecret", "idsecret:///zuber.jpg?id=1234", true},
+		{"idsecret with secrets", "idsecret:///zuber.jpg?id=1234&secret=s33kret&secret_o=0r1g1nal", true},
+		{"idsecret with format and label", "idsecret:///zuber.png?id=1234&format=jpg&label=o", true},
+		{"idsecret not an image", "idsecret:///zuber.txt?id=1234", false},
+		{"idsecret not an image with format", "idsecret:///zuber.txt?id=1234&format=jpg&label=o", false},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			u, err := uri.NewURI(tt.uri)
+
+			if err != nil {
+				t.Fatalf("Failed to parse %s, %s", tt.uri, err)
+			}
+
+			err = ValidateURI(u)
+
+			if tt.valid && err != nil {
+				t.Fatalf("Expected %s to be valid, %s", tt.uri, err)
+			}
+
+			if !tt.valid && err == nil {
+				t.Fatalf("Expected %s to be invalid", tt.uri)
+			}
+		})
+	}
+}
+
+func TestValidateURIUnsupportedDriver(t *testing.T) {
+
+	err := ValidateURI(&unsupportedURI{})
+
+	if err == nil {
+		t.Fatal("Expected URI with unsupported driver to be invalid")
+	}
+}
+
+func TestProcessCommandURIs(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		uri      string
+		expected string
+	}{
+		{"file", "file:///zuber.jpg", "file:///zuber.jpg"},
+		{"file with target", "file:///zuber.jpg?target=zuber", "file:///zuber.jpg?target=zuber"},
+		{"rewrite", "rewrite:///zuber.jpg?target=avocado/toast", "rewrite:///zuber.jpg?target=avocado%2Ftoast"},
+		{"idsecret", "idsecret:///zuber.jpg?id=1234&secret=s33kret&secret_o=0r1g1nal", "idsecret:///zuber.jpg?id=1234&secret=s33kret&secret_o=0r1g1nal"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			u, err := uri.NewURI(tt.uri)
+
+			if err != nil {
+				t.Fatalf("Failed to parse %s, %s", tt.uri, err)
+			}
+
+			opts := &ProcessTaskOptions{
+				Config:       "/etc/go-iiif/config.json",
+				Instructions: "/etc/go-iiif/instructions.json",
+				URIs:         []uri.URI{u},
+			}
+
+			cmd, err := ProcessCommand(opts)
+
+			if err != nil {
+				t.Fatalf("Failed to build command for %s, %s", tt.uri, err)
+			}
+
+			args := aws.StringValueSlice(cmd)
+
+			if len(args) < 2 || args[len(args)-2] != "-uri" {
+				t.Fatalf("Expected command for %s to end with a -uri flag, got %v", tt.uri, args)
+			}
+
+			if args[len(args)-1] != tt.expected {
+				t.Fatalf("Expected URI %s, got %s", tt.expected, args[len(args)-1])
+			}
+		})
+	}
+}
+
+func TestRedactURI(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		uri      string
+		expected string
+	}{
+		{"file", "file:///zuber.jpg", "file:///zuber.jpg"},
+		{"escaped", "file:///my%20photo%281%29.jpg", "file:///my%20photo%281%29.jpg"},
+		{"idsecret without secrets", "idsecret:///zuber.jpg?id=1234", "idsecret:///zuber.jpg?id=1234"},
+		{"idsecret", "idsecret:///zuber.jpg?id=1234&secret=abc&secret_o=def&format=png&label=b", "idsecret:///zuber.jpg?id=1234&secret=REDACTED&secret_o=REDACTED&format=png&label=b"},
+		{"secret first", "idsecret:///zuber.jpg?secret=abc&id=1234", "idsecret:///zuber.jpg?secret=REDACTED&id=1234"},
+		{"escaped key", "idsecret:///zuber.jpg?id=1234&secret%5Fo=def", "idsecret:///zuber.jpg?id=1234&secret%5Fo=REDACTED"},
+		{"empty secret", "idsecret:///zuber.jpg?id=1234&secret=", "idsecret:///zuber.jpg?id=1234&secret=REDACTED"},
+		{"similar key", "idsecret:///zuber.jpg?id=1234&secrets=abc", "idsecret:///zuber.jpg?id=1234&secrets=abc"},
+	}
+
+	for _, tt := range tests {
+
+		t.Run(tt.name, func(t *testing.T) {
+
+			redacted := RedactURI(tt.uri)
+
+			if redacted != tt.expected {
+				t.Fatalf("Expected %s, got %s", tt.expected, redacted)
+			}
+		})
+	}
+}
+
+func TestProcessTaskResponseRedacted(t *testing.T) {
+
+	str_uri := "idsecret:///zuber.jpg?id=1234&secret=abc&secret_o=def"
+	redacted_uri := "idsecret:///zuber.jpg?id=1234&secret=REDACTED&secret_o=REDACTED"
+
+	u, err := ParseURI(str_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to parse %s, %s", str_uri, err)
+	}
+
+	rsp := &ProcessTaskResponse{
+		TaskId: "task",
+		URIs:   []uri.URI{u},
+		Results: map[string]*ProcessResult{
+			str_uri: &ProcessResult{
+				URIs: map[string]string{"o": "1234_def_o.jpg"},
+			},
+		},
+	}
+
+	redacted := rsp.Redacted()
+
+	if redacted.URIs[0].String() != redacted_uri || redacted.URIs[0].Origin() != u.Origin() {
+		t.Fatalf("Expected URI %s, got %s", redacted_uri, redacted.URIs[0].String())
+	}
+
+	result, ok := redacted.Results[redacted_uri]
+
+	if !ok || len(redacted.Results) != 1 {
+		t.Fatalf("Expected results for %s, got %v", redacted_uri, redacted.Results)
+	}
+
+	// derivatives are named for their secrets so that they can be found
+
+	if result.URIs["o"] != "1234_def_o.jpg" {
+		t.Fatalf("Expected derivative URIs to be left as is, got %v", result.URIs)
+	}
+
+	// the original is unchanged
+
+	if rsp.URIs[0].String() != str_uri {
+		t.Fatalf("Expected original URI %s, got %s", str_uri, rsp.URIs[0].String())
+	}
+
+	_, ok = rsp.Results[str_uri]
+
+	if !ok {
+		t.Fatalf("Expected original results for %s, got %v", str_uri, rsp.Results)
+	}
+
+	result_rsp := &JobResult{JobId: "job", Task: rsp}
+
+	enc, err := json.Marshal(result_rsp.Redacted())
+
+	if err != nil {
+		t.Fatalf("Failed to encode result, %s", err)
+	}
+
+	if strings.Contains(string(enc), "secret=abc") || strings.Contains(string(enc), "secret_o=def") {
+		t.Fatalf("Expected encoded result to be redacted, got %s", string(enc))
+	}
+}
@@ -0,0 +1,160 @@
+package ecs
+
+import (
+	"context"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	aws_ecs "github.com/aws/aws-sdk-go/service/ecs"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+func (l *ProcessTaskLauncher) waitForTask(ctx context.Context, opts *ProcessTaskOptions, task_rsp *ProcessTaskResponse) error {
+
+	var stopped *aws_ecs.Task
+	var events []*cloudwatchlogs.OutputLogEvent
+
+	if opts.Follow && l.logs != nil {
+
+		t, ev, err := l.followTask(ctx, opts, task_rsp.TaskId)
+
+		if err != nil {
+			return err
+		}
+
+		stopped = t
+		events = ev
+
+	} else {
+
+		pending := &aws_ecs.DescribeTasksInput{
+			Cluster: aws.String(opts.Cluster),
+			Tasks: []*string{
+				aws.String(task_rsp.TaskId),
+			},
+		}
+
+		err := l.service.WaitUntilTasksStoppedWithContext(ctx, pending)
+
+		if err != nil {
+			return err
+		}
+
+		t, err := DescribeTask(l.service, opts.Cluster, task_rsp.TaskId)
+
+		if err != nil {
+			return err
+		}
+
+		stopped = t
+	}
+
+	task_rsp.setTask(stopped)
+
+	if l.logs != nil {
+
+		if len(events) > 0 {
+			task_rsp.Results = ParseProcessResults(events)
+		} else {
+
+			results, err := l.readProcessResults(stopped, opts.Container)
+
+			if err != nil {
+				log.Printf("[WARNING] Unable to read output for task %s: %s\n", task_rsp.TaskId, err)
+			} else {
+				task_rsp.Results = results
+			}
+		}
+	}
+
+	return task_rsp.ExitError()
+}
+
+// followTask polls a task, writing any new events in its log stream to opts.FollowWriter (or
+// STDERR) as they arrive, until it has stopped. It returns the stopped task and all the log
+// events that were read.
+func (l *ProcessTaskLauncher) followTask(ctx context.Context, opts *ProcessTaskOptions, task_id string) (*aws_ecs.Task, []*cloudwatchlogs.OutputLogEvent, error) {
+
+	var wr io.Writer
+	wr = os.Stderr
+
+	if opts.FollowWriter != nil {
+		wr = opts.FollowWriter
+	}
+
+	var stream *LogStream
+
+	events := make([]*cloudwatchlogs.OutputLogEvent, 0)
+	next_token := ""
+
+	follow := true
+	stopped := false
+
+	for {
+
+		task, err := DescribeTask(l.service, opts.Cluster, task_id)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// if we can't work out where the logs are then keep polling the
+		// task until it stops but don't try to read anything
+
+		if stream == nil && follow {
+
+			s, err := LogStreamForTask(l.service, task, opts.Container)
+
+			if err != nil {
+				log.Printf("[WARNING] Unable to follow logs for task %s: %s\n", task_id, err)
+				follow = false
+			}
+
+			stream = s
+		}
+
+		// don't bother looking for logs until the container has actually started
+
+		if follow && aws.StringValue(task.LastStatus) != TASK_STATUS_PENDING {
+
+			new_events, token, err := ReadLogEvents(l.logs, stream, next_token)
+
+			if err != nil && !isResourceNotFound(err) {
+				return nil, nil, err
+			}
+
+			next_token = token
+
+			for _, e := range new_events {
+
+				ts := aws.MillisecondsTimeValue(e.Timestamp)
+				msg := strings.TrimRight(aws.StringValue(e.Message), "\n")
+
+				fmt.Fprintf(wr, "%s %s\n", ts.Format(time.RFC3339), msg)
+				events = append(events, e)
+			}
+		}
+
+		// once the task has stopped poll one more time to pick up any
+		// events that were still being ingested
+
+		if stopped {
+			return task, events, nil
+		}
+
+		if aws.StringValue(task.LastStatus) == TASK_STATUS_STOPPED {
+			stopped = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(l.poll_interval):
+			// pass
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package ecs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/whosonfirst/go-whosonfirst-aws/session"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SQS_MAX_MESSAGES is the largest number of messages that can be received, deleted or
+// updated in a single SQS request.
+const SQS_MAX_MESSAGES int = 10
+
+// SQS_MAX_WAIT_TIME is the longest that a single ReceiveMessage request can wait for
+// messages to arrive.
+const SQS_MAX_WAIT_TIME time.Duration = 20 * time.Second
+
+type SQSService interface {
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	ChangeMessageVisibilityBatch(*sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error)
+	DeleteMessageBatch(*sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error)
+}
+
+type WorkerOptions struct {
+	QueueURL string
+	// The maximum number of messages in a batch. The default is 10.
+	BatchSize int
+	// The longest to wait for a batch to fill up, once its first message has arrived, before
+	// launching it. The default is 30 seconds.
+	BatchWindow time.Duration
+	// How long received messages are hidden from other consumers. It is extended for as long
+	// as the messages are being batched and processed. The default is 5 minutes.
+	VisibilityTimeout time.Duration
+}
+
+// Worker long-polls an SQS queue for messages wrapping S3 event notifications (or job
+// requests), groups them in to batches and launches tasks for each batch. Messages are
+// only deleted once the URIs they reference have been launched successfully or, if the
+// task options have Wait set, once those tasks have completed successfully. Messages that
+// fail are left to reappear on the queue once their visibility timeout expires.
+type Worker struct {
+	launcher     *ProcessTaskLauncher
+	service      SQSService
+	opts         *ProcessTaskOptions
+	batch_opts   *BatchOptions
+	worker_opts  *WorkerOptions
+	retry_period time.Duration
+}
+
+func NewWorkerWithDSN(sqs_dsn string, opts *ProcessTaskOptions, batch_opts *BatchOptions, worker_opts *WorkerOptions) (*Worker, error) {
+
+	l, err := NewProcessTaskLauncherWithDSN(opts.DSN)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sess, err := session.NewSessionWithDSN(sqs_dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	svc := sqs.New(sess)
+
+	return NewWorker(l, svc, opts, batch_opts, worker_opts)
+}
+
+func NewWorker(l *ProcessTaskLauncher, svc SQSService, opts *ProcessTaskOptions, batch_opts *BatchOptions, worker_opts *WorkerOptions) (*Worker, error) {
+
+	if worker_opts.QueueURL == "" {
+		return nil, errors.New("Missing SQS queue URL")
+	}
+
+	wo := *worker_opts
+
+	if wo.BatchSize < 1 {
+		wo.BatchSize = SQS_MAX_MESSAGES
+	}
+
+	if wo.BatchWindow <= 0 {
+		wo.BatchWindow = 30 * time.Second
+	}
+
+	if wo.VisibilityTimeout <= 0 {
+		wo.VisibilityTimeout = 5 * time.Minute
+	}
+
+	if wo.VisibilityTimeout < 2*time.Second || wo.VisibilityTimeout > 12*time.Hour {
+		msg := fmt.Sprintf("Invalid visibility timeout %s, must be between 2 seconds and 12 hours", wo.VisibilityTimeout)
+		return nil, errors.New(msg)
+	}
+
+	w := Worker{
+		launcher:     l,
+		service:      svc,
+		opts:         opts,
+		batch_opts:   batch_opts,
+		worker_opts:  &wo,
+		retry_period: 5 * time.Second,
+	}
+
+	return &w, nil
+}
+
+// Run receives and processes batches of messages until ctx is cancelled. Once that happens
+// no more messages are received but any batch that has already been received is processed
+// (and waited on, if necessary) before Run returns.
+func (w *Worker) Run(ctx context.Context) error {
+
+	log.Printf("Receiving messages from %s\n", w.worker_opts.QueueURL)
+
+	for ctx.Err() == nil {
+
+		hb := w.startHeartbeat()
+
+		msgs := w.receiveBatch(ctx, hb)
+
+		if len(msgs) > 0 {
+			w.processBatch(hb, msgs)
+		}
+
+		hb.Stop()
+	}
+
+	log.Println("Worker stopped")
+	return nil
+}
+
+// receiveBatch returns messages as soon as there are BatchSize of them, BatchWindow has passed
+// since the first one arrived or ctx has been cancelled.
+func (w *Worker) receiveBatch(ctx context.Context, hb *visibilityHeartbeat) []*sqs.Message {
+
+	msgs := make([]*sqs.Message, 0)
+
+	var deadline time.Time
+
+	for len(msgs) < w.worker_opts.BatchSize && ctx.Err() == nil {
+
+		wait := SQS_MAX_WAIT_TIME
+
+		if !deadline.IsZero() {
+
+			remaining := time.Until(deadline)
+
+			if remaining <= 0 {
+				break
+			}
+
+			if remaining < wait {
+				wait = remaining
+			}
+		}
+
+		max := w.worker_opts.BatchSize - len(msgs)
+
+		if max > SQS_MAX_MESSAGES {
+			max = SQS_MAX_MESSAGES
+		}
+
+		input := &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(w.worker_opts.QueueURL),
+			MaxNumberOfMessages: aws.Int64(int64(max)),
+			WaitTimeSeconds:     aws.Int64(int64(wait / time.Second)),
+			VisibilityTimeout:   aws.Int64(int64(w.worker_opts.VisibilityTimeout / time.Second)),
+		}
+
+		rsp, err := w.service.ReceiveMessageWithContext(ctx, input)
+
+		if err != nil {
+
+			if ctx.Err() != nil {
+				break
+			}
+
+			log.Printf("[WARNING] Failed to receive messages: %s\n", err)
+
+			// don't sit on messages we already have while SQS is unhappy
+
+			if len(msgs) > 0 {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(w.retry_period):
+			}
+
+			continue
+		}
+
+		for _, m := range rsp.Messages {
+			msgs = append(msgs, m)
+			hb.Add(m)
+		}
+
+		if len(msgs) > 0 && deadline.IsZero() {
+			deadline = time.Now().Add(w.worker_opts.BatchWindow)
+		}
+	}
+
+	return msgs
+}
+
+func (w *Worker) processBatch(hb *visibilityHeartbeat, msgs []*sqs.Message) {
+
+	batch := make([]*sqsMessage, len(msgs))
+
+	for i, m := range msgs {
+		batch[i] = &sqsMessage{
+			Id:   aws.StringValue(m.MessageId),
+			Body: aws.StringValue(m.Body),
+		}
+	}
+
+	// this deliberately does not use the context passed to Run so that
+	// a batch that has been received is always processed in full
+
+	failed := w.launcher.launchSQSMessages(context.Background(), w.opts, w.batch_opts, batch)
+
+	hb.Stop()
+
+	done := make([]*sqs.Message, 0)
+
+	for _, m := range msgs {
+
+		if failed[aws.StringValue(m.MessageId)] {
+			continue
+		}
+
+		done = append(done, m)
+	}
+
+	log.Printf("Processed batch of %d messages, %d failed\n", len(msgs), len(msgs)-len(done))
+
+	err := w.deleteMessages(done)
+
+	if err != nil {
+		log.Printf("[WARNING] Failed to delete messages: %s\n", err)
+	}
+}
+
+func (w *Worker) deleteMessages(msgs []*sqs.Message) error {
+
+	for _, chunk := range chunkMessages(msgs) {
+
+		entries := make([]*sqs.DeleteMessageBatchRequestEntry, len(chunk))
+
+		for i, m := range chunk {
+			entries[i] = &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: m.ReceiptHandle,
+			}
+		}
+
+		input := &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(w.worker_opts.QueueURL),
+			Entries:  entries,
+		}
+
+		rsp, err := w.service.DeleteMessageBatch(input)
+
+		if err != nil {
+			return err
+		}
+
+		for _, f := range rsp.Failed {
+			log.Printf("[WARNING] Failed to delete message: %s\n", aws.StringValue(f.Message))
+		}
+	}
+
+	return nil
+}
+
+func chunkMessages(msgs []*sqs.Message) [][]*sqs.Message {
+
+	chunks := make([][]*sqs.Message, 0)
+
+	for len(msgs) > 0 {
+
+		n := SQS_MAX_MESSAGES
+
+		if len(msgs) < n {
+			n = len(msgs)
+		}
+
+		chunks = append(chunks, msgs[:n])
+		msgs = msgs[n:]
+	}
+
+	return chunks
+}
+
+// visibilityHeartbeat periodically extends the visibility timeout of the messages in a
+// batch so that they aren't delivered to another consumer while they are being processed.
+type visibilityHeartbeat struct {
+	worker *Worker
+	mu     *sync.Mutex
+	msgs   []*sqs.Message
+	done   chan bool
+	wg     *sync.WaitGroup
+	once   *sync.Once
+}
+
+func (w *Worker) startHeartbeat() *visibilityHeartbeat {
+
+	hb := &visibilityHeartbeat{
+		worker: w,
+		mu:     new(sync.Mutex),
+		msgs:   make([]*sqs.Message, 0),
+		done:   make(chan bool),
+		wg:     new(sync.WaitGroup),
+		once:   new(sync.Once),
+	}
+
+	hb.wg.Add(1)
+
+	go func() {
+
+		defer hb.wg.Done()
+
+		ticker := time.NewTicker(w.worker_opts.VisibilityTimeout / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-hb.done:
+				return
+			case <-ticker.C:
+				hb.extend()
+			}
+		}
+	}()
+
+	return hb
+}
+
+func (hb *visibilityHeartbeat) Add(m *sqs.Message) {
+
+	hb.mu.Lock()
+	defer hb.mu.Unlock()
+
+	hb.msgs = append(hb.msgs, m)
+}
+
+// Stop stops extending the visibility timeout. It is safe to call more than once.
+func (hb *visibilityHeartbeat) Stop() {
+
+	hb.once.Do(func() {
+		close(hb.done)
+	})
+
+	hb.wg.Wait()
+}
+
+func (hb *visibilityHeartbeat) extend() {
+
+	hb.mu.Lock()
+	msgs := hb.msgs
+	hb.mu.Unlock()
+
+	timeout := int64(hb.worker.worker_opts.VisibilityTimeout / time.Second)
+
+	for _, chunk := range chunkMessages(msgs) {
+
+		entries := make([]*sqs.ChangeMessageVisibilityBatchRequestEntry, len(chunk))
+
+		for i, m := range chunk {
+			entries[i] = &sqs.ChangeMessageVisibilityBatchRequestEntry{
+				Id:                aws.String(strconv.Itoa(i)),
+				ReceiptHandle:     m.ReceiptHandle,
+				VisibilityTimeout: aws.Int64(timeout),
+			}
+		}
+
+		input := &sqs.ChangeMessageVisibilityBatchInput{
+			QueueUrl: aws.String(hb.worker.worker_opts.QueueURL),
+			Entries:  entries,
+		}
+
+		rsp, err := hb.worker.service.ChangeMessageVisibilityBatch(input)
+
+		if err != nil {
+			log.Printf("[WARNING] Failed to extend visibility timeout: %s\n", err)
+			continue
+		}
+
+		for _, f := range rsp.Failed {
+			log.Printf("[WARNING] Failed to extend visibility timeout: %s\n", aws.StringValue(f.Message))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package ecs
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSQSService returns Messages from the first call to ReceiveMessageWithContext and then
+// calls cancel, so that a Worker processes exactly one batch.
+type fakeSQSService struct {
+	Messages []*sqs.Message
+	Deleted  []string
+	cancel   context.CancelFunc
+	mu       *sync.Mutex
+	received bool
+}
+
+func (svc *fakeSQSService) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	rsp := &sqs.ReceiveMessageOutput{
+		Messages: []*sqs.Message{},
+	}
+
+	if svc.received {
+		svc.cancel()
+		return rsp, nil
+	}
+
+	svc.received = true
+	rsp.Messages = svc.Messages
+
+	return rsp, nil
+}
+
+func (svc *fakeSQSService) ChangeMessageVisibilityBatch(input *sqs.ChangeMessageVisibilityBatchInput) (*sqs.ChangeMessageVisibilityBatchOutput, error) {
+	return &sqs.ChangeMessageVisibilityBatchOutput{}, nil
+}
+
+func (svc *fakeSQSService) DeleteMessageBatch(input *sqs.DeleteMessageBatchInput) (*sqs.DeleteMessageBatchOutput, error) {
+
+	svc.mu.Lock()
+	defer svc.mu.Unlock()
+
+	for _, e := range input.Entries {
+		svc.Deleted = append(svc.Deleted, aws.StringValue(e.ReceiptHandle))
+	}
+
+	return &sqs.DeleteMessageBatchOutput{}, nil
+}
+
+func TestWorkerRun(t *testing.T) {
+
+	results := make(chan *JobResult, 10)
+
+	callback := httptest.NewServer(http.HandlerFunc(func(rsp http.ResponseWriter, req *http.Request) {
+
+		var result *JobResult
+
+		err := json.NewDecoder(req.Body).Decode(&result)
+
+		if err != nil {
+			t.Errorf("Failed to decode job result, %s", err)
+		}
+
+		results <- result
+	}))
+
+	defer callback.Close()
+
+	bodies := map[string]string{
+		"a":   `{"uris":["file:///a.jpg"]}`,
+		"b":   `{"uris":["file:///b.jpg"],"report":true,"report_name":"x.json","callback":"` + callback.URL + `"}`,
+		"bad": `{"uris":["file:///notes.txt"]}`,
+	}
+
+	msgs := make([]*sqs.Message, 0)
+
+	for _, id := range []string{"a", "b", "bad"} {
+
+		m := &sqs.Message{
+			MessageId:     aws.String(id),
+			ReceiptHandle: aws.String("receipt-" + id),
+			Body:          aws.String(bodies[id]),
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sqs_svc := &fakeSQSService{
+		Messages: msgs,
+		Deleted:  make([]string, 0),
+		cancel:   cancel,
+		mu:       new(sync.Mutex),
+	}
+
+	ecs_svc := NewFakeECSService()
+	l := NewProcessTaskLauncher(ecs_svc, nil)
+
+	worker_opts := &WorkerOptions{
+		QueueURL:    "https://sqs.us-east-1.amazonaws.com/000000000000/iiif",
+		BatchWindow: 10 * time.Millisecond,
+	}
+
+	w, err := NewWorker(l, sqs_svc, newTestProcessTaskOptions(t), &BatchOptions{}, worker_opts)
+
+	if err != nil {
+		t.Fatalf("Failed to create worker, %s", err)
+	}
+
+	err = w.Run(ctx)
+
+	if err != nil {
+		t.Fatalf("Worker failed, %s", err)
+	}
+
+	// the message that failed is left on the queue
+
+	sort.Strings(sqs_svc.Deleted)
+
+	if strings.Join(sqs_svc.Deleted, " ") != "receipt-a receipt-b" {
+		t.Fatalf("Unexpected deleted messages %v", sqs_svc.Deleted)
+	}
+
+	if len(ecs_svc.RunTaskInputs) != 2 {
+		t.Fatalf("Expected one task for each job request, got %d", len(ecs_svc.RunTaskInputs))
+	}
+
+	for _, input := range ecs_svc.RunTaskInputs {
+
+		cmd := strings.Join(aws.StringValueSlice(input.Overrides.ContainerOverrides[0].Command), " ")
+		is_report := strings.Contains(cmd, "-report -report-name x.json")
+
+		if strings.Contains(cmd, "file:///b.jpg") != is_report {
+			t.Fatalf("Expected only job request b to report, got %s", cmd)
+		}
+	}
+
+	select {
+	case result := <-results:
+
+		if result.JobId == "" || result.Task == nil || result.Error != "" {
+			t.Fatalf("Unexpected job result %v", result)
+		}
+
+		if len(result.Task.URIs) != 1 || result.Task.URIs[0].String() != "file:///b.jpg" {
+			t.Fatalf("Expected job result for file:///b.jpg, got %v", result.Task.URIs)
+		}
+
+	default:
+		t.Fatal("Expected job request b's callback to be posted")
+	}
+
+	if len(results) != 0 {
+		t.Fatalf("Expected one job result, got %d more", len(results))
+	}
+}